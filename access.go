@@ -0,0 +1,230 @@
+package fmutils
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Get returns the value at the given path of the msg.
+//
+// The path has the syntax of ParseNestedMask: the segments are the field names, the map keys and the indices of the
+// list elements, e.g. "user.name", "attributes.a1.tags" or "gallery.0.path", a negative index counts from the end of
+// the list, e.g. "gallery.-1" is the last element. A selector, e.g. "gallery[photo_id=2].path", picks the first
+// element which key field has the given value. Wildcards are not allowed.
+// The unpopulated fields and the missing map entries have their default values, an index out of range is an error.
+// A malformed path is reported as a *SyntaxError and a path that does not match the message as a *PathError.
+func Get(msg proto.Message, path string) (protoreflect.Value, error) {
+	t, err := resolvePath(msg.ProtoReflect(), path, false)
+	if err != nil {
+		return protoreflect.Value{}, err
+	}
+	switch {
+	case t.mapKey.IsValid():
+		xmap := t.m.Get(t.fd).Map()
+		if v := xmap.Get(t.mapKey); v.IsValid() {
+			return v, nil
+		}
+		return xmap.NewValue(), nil
+	case t.index >= 0:
+		return t.m.Get(t.fd).List().Get(t.index), nil
+	default:
+		return t.m.Get(t.fd), nil
+	}
+}
+
+// Set sets the value at the given path of the msg.
+//
+// The path has the syntax of Get. The missing submessages and map entries on the path are created, the list elements
+// must exist. The value must be of the kind of the field, list element or map value at the path, e.g.
+// protoreflect.ValueOfInt32 for an int32 field or a protoreflect.List for a repeated field. The lists and the maps must
+// be of the types of the field, e.g. a map with int32 keys can not be set to a map field with int64 keys, an error is
+// returned for them rather than a panic.
+func Set(msg proto.Message, path string, v protoreflect.Value) error {
+	// The path is resolved without creating the missing messages first, so that the msg is left intact on errors.
+	t, err := resolvePath(msg.ProtoReflect(), path, false)
+	if err != nil {
+		return err
+	}
+	switch {
+	case t.mapKey.IsValid():
+		err = checkValue(t.fd.MapValue(), v)
+	case t.index >= 0:
+		err = checkValue(t.fd, v)
+	default:
+		err = checkFieldValue(t.m, t.fd, v)
+	}
+	if err != nil {
+		return fmt.Errorf("fmutils: can not set %s: %w", path, err)
+	}
+	t, _ = resolvePath(msg.ProtoReflect(), path, true)
+	switch {
+	case t.mapKey.IsValid():
+		t.m.Mutable(t.fd).Map().Set(t.mapKey, v)
+	case t.index >= 0:
+		t.m.Mutable(t.fd).List().Set(t.index, v)
+	default:
+		t.m.Set(t.fd, v)
+	}
+	return nil
+}
+
+// pathTarget is the value a path resolves to: a field of the message, a map value if mapKey is valid or a list element
+// if index is not negative.
+type pathTarget struct {
+	m      protoreflect.Message
+	fd     protoreflect.FieldDescriptor
+	mapKey protoreflect.MapKey
+	index  int
+}
+
+// resolvePath resolves the path in the message, if mutable is set the missing submessages and map entries on the path
+// are created.
+func resolvePath(m protoreflect.Message, path string, mutable bool) (pathTarget, error) {
+	segments, err := splitPath(path, false)
+	if err != nil {
+		return pathTarget{}, err
+	}
+	if len(segments) == 0 {
+		return pathTarget{}, &SyntaxError{Path: path, Msg: "empty path"}
+	}
+	invalid := func(i int, reason error) (pathTarget, error) {
+		return pathTarget{}, &PathError{Path: path, Segment: segments[i], Err: reason}
+	}
+
+	for i, segment := range segments {
+		if segment == wildcard {
+			return invalid(i, ErrInvalidWildcard)
+		}
+	}
+
+	for i := 0; i < len(segments); i++ {
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(segments[i]))
+		if fd == nil {
+			return invalid(i, ErrUnknownField)
+		}
+		t := pathTarget{m: m, fd: fd, index: -1}
+		vfd := fd
+		switch {
+		case i == len(segments)-1:
+			return t, nil
+		case fd.IsMap():
+			i++
+			if t.mapKey, err = parseMapKey(fd.MapKey(), segments[i]); err != nil {
+				return invalid(i, ErrInvalidMapKey)
+			}
+			vfd = fd.MapValue()
+		case fd.IsList():
+			i++
+			if field, value, ok := splitSelector(segments[i]); ok {
+				if t.index = selectElement(m.Get(fd).List(), fd, field, value); t.index < 0 {
+					return invalid(i, ErrInvalidSelector)
+				}
+				break
+			}
+			index, ok := parseIndex(segments[i])
+			if ok {
+				index, ok = resolveIndex(index, m.Get(fd).List().Len())
+			}
+			if !ok {
+				return invalid(i, ErrInvalidIndex)
+			}
+			t.index = index
+		}
+		if i == len(segments)-1 {
+			return t, nil
+		}
+		if vfd.Message() == nil {
+			return invalid(i+1, ErrScalarDescent)
+		}
+		m = t.message(mutable)
+	}
+	return pathTarget{}, nil
+}
+
+// selectElement returns the index of the first element of the list of the repeated field that matches the selector,
+// -1 if there is no such element or the selector is invalid.
+func selectElement(list protoreflect.List, fd protoreflect.FieldDescriptor, field, value string) int {
+	sfd, v, err := resolveSelector(fd, field, value)
+	if err != nil {
+		return -1
+	}
+	s := selector{fd: sfd, value: v}
+	for i := 0; i < list.Len(); i++ {
+		if s.matches(list.Get(i)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// message returns the message value of the target, a read-only empty message if the value is missing and mutable is
+// not set.
+func (t pathTarget) message(mutable bool) protoreflect.Message {
+	switch {
+	case t.mapKey.IsValid() && mutable:
+		return t.m.Mutable(t.fd).Map().Mutable(t.mapKey).Message()
+	case t.mapKey.IsValid():
+		xmap := t.m.Get(t.fd).Map()
+		if v := xmap.Get(t.mapKey); v.IsValid() {
+			return v.Message()
+		}
+		return xmap.NewValue().Message()
+	case t.index >= 0:
+		return t.m.Get(t.fd).List().Get(t.index).Message()
+	case mutable:
+		return t.m.Mutable(t.fd).Message()
+	default:
+		return t.m.Get(t.fd).Message()
+	}
+}
+
+// checkFieldValue checks that the value is valid for the field of the message, the values of the repeated and map
+// fields must be lists and maps of valid keys and values.
+func checkFieldValue(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) error {
+	switch {
+	case fd.IsMap():
+		xmap, ok := v.Interface().(protoreflect.Map)
+		if !ok {
+			return fmt.Errorf("%T is not a valid map value", v.Interface())
+		}
+		err := checkValue(fd.MapValue(), xmap.NewValue())
+		if err != nil {
+			return err
+		}
+		xmap.Range(func(mk protoreflect.MapKey, _ protoreflect.Value) bool {
+			err = checkValue(fd.MapKey(), mk.Value())
+			return err == nil
+		})
+		if err != nil {
+			return fmt.Errorf("map key: %w", err)
+		}
+		return checkAssignable(m, fd, v)
+	case fd.IsList():
+		list, ok := v.Interface().(protoreflect.List)
+		if !ok {
+			return fmt.Errorf("%T is not a valid list value", v.Interface())
+		}
+		if err := checkValue(fd, list.NewElement()); err != nil {
+			return err
+		}
+		return checkAssignable(m, fd, v)
+	default:
+		return checkValue(fd, v)
+	}
+}
+
+// checkAssignable checks that the list or the map can be set to the field by setting it to the field of an empty
+// message of the same type. The lists and the maps of the other fields may hold values of the same kinds and still
+// be of other types, e.g. an empty map with int32 keys instead of int64 keys or a list of another enum, which the
+// message implementations panic on.
+func checkAssignable(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the value can not be set to %s: %v", fd.FullName(), r)
+		}
+	}()
+	m.New().Set(fd, v)
+	return nil
+}
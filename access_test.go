@@ -0,0 +1,202 @@
+package fmutils
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestGet(t *testing.T) {
+	msg := &testproto.Profile{
+		User:            &testproto.User{UserId: 1, Name: "name"},
+		LoginTimestamps: []int64{1, 2},
+		Gallery:         []*testproto.Photo{{PhotoId: 1, Path: "path 1"}, {PhotoId: 2}},
+		Attributes: map[string]*testproto.Attribute{
+			"a.1": {Tags: map[string]string{"t1": "1"}},
+		},
+	}
+	tests := []struct {
+		name string
+		msg  proto.Message
+		path string
+		want interface{}
+	}{
+		{name: "scalar field", msg: msg, path: "user.name", want: "name"},
+		{name: "unpopulated field", msg: msg, path: "photo.dimensions.width", want: int32(0)},
+		{name: "list element", msg: msg, path: "login_timestamps.1", want: int64(2)},
+		{name: "list element field", msg: msg, path: "gallery.0.path", want: "path 1"},
+		{name: "negative index", msg: msg, path: "gallery.-2.photo_id", want: int64(1)},
+		{name: "quoted map key", msg: msg, path: "attributes.`a.1`.tags.t1", want: "1"},
+		{name: "missing map entry", msg: msg, path: "attributes.a2.tags.t1", want: ""},
+		{
+			name: "integer map key",
+			msg:  &testproto.Leaderboard{Scores: map[int64]*testproto.Score{2: {Value: 10}}},
+			path: "scores.+2.value",
+			want: int64(10),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Get(tt.msg, tt.path)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got.Interface() != tt.want {
+				t.Errorf("Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	user, err := Get(msg, "user")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !proto.Equal(user.Message().Interface(), msg.User) {
+		t.Errorf("Get() = %v, want %v", user, msg.User)
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   proto.Message
+		path  string
+		value protoreflect.Value
+		want  proto.Message
+	}{
+		{
+			name:  "intermediate messages are created",
+			msg:   &testproto.Profile{},
+			path:  "photo.dimensions.width",
+			value: protoreflect.ValueOfInt32(50),
+			want:  &testproto.Profile{Photo: &testproto.Photo{Dimensions: &testproto.Dimensions{Width: 50}}},
+		},
+		{
+			name:  "list element",
+			msg:   &testproto.Profile{Gallery: []*testproto.Photo{{PhotoId: 1}, {PhotoId: 2}}},
+			path:  "gallery.1.path",
+			value: protoreflect.ValueOfString("path"),
+			want:  &testproto.Profile{Gallery: []*testproto.Photo{{PhotoId: 1}, {PhotoId: 2, Path: "path"}}},
+		},
+		{
+			name:  "map entries are created",
+			msg:   &testproto.Profile{},
+			path:  "attributes.a1.tags.t1",
+			value: protoreflect.ValueOfString("1"),
+			want: &testproto.Profile{Attributes: map[string]*testproto.Attribute{
+				"a1": {Tags: map[string]string{"t1": "1"}},
+			}},
+		},
+		{
+			name:  "integer map key",
+			msg:   &testproto.Leaderboard{},
+			path:  "labels.-1",
+			value: protoreflect.ValueOfString("minus one"),
+			want:  &testproto.Leaderboard{Labels: map[int64]string{-1: "minus one"}},
+		},
+		{
+			name:  "enum field",
+			msg:   &testproto.Event{},
+			path:  "status",
+			value: protoreflect.ValueOfEnum(protoreflect.EnumNumber(testproto.Status_OK)),
+			want:  &testproto.Event{Changed: &testproto.Event_Status{Status: testproto.Status_OK}},
+		},
+		{
+			name:  "message field",
+			msg:   &testproto.Profile{User: &testproto.User{UserId: 1}},
+			path:  "user",
+			value: protoreflect.ValueOfMessage((&testproto.User{Name: "name"}).ProtoReflect()),
+			want:  &testproto.Profile{User: &testproto.User{Name: "name"}},
+		},
+		{
+			name:  "repeated field",
+			msg:   &testproto.Profile{LoginTimestamps: []int64{1}},
+			path:  "login_timestamps",
+			value: (&testproto.Profile{LoginTimestamps: []int64{2, 3}}).ProtoReflect().Get(loginTimestampsField),
+			want:  &testproto.Profile{LoginTimestamps: []int64{2, 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Set(tt.msg, tt.path, tt.value); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if !proto.Equal(tt.msg, tt.want) {
+				t.Errorf("msg %v, want %v", tt.msg, tt.want)
+			}
+		})
+	}
+}
+
+var loginTimestampsField = (&testproto.Profile{}).ProtoReflect().Descriptor().Fields().ByName("login_timestamps")
+
+func TestGetSet_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		value   protoreflect.Value
+		wantErr error
+	}{
+		{name: "unknown field", path: "user.unknown", value: protoreflect.ValueOfString(""), wantErr: ErrUnknownField},
+		{name: "scalar descent", path: "user.name.first", value: protoreflect.ValueOfString(""), wantErr: ErrScalarDescent},
+		{name: "wildcard", path: "attributes.*.tags", value: protoreflect.ValueOfString(""), wantErr: ErrInvalidWildcard},
+		{name: "index out of range", path: "gallery.2.path", value: protoreflect.ValueOfString(""), wantErr: ErrInvalidIndex},
+		{name: "negative index out of range", path: "gallery.-3", value: protoreflect.ValueOfString(""),
+			wantErr: ErrInvalidIndex},
+		{name: "not an index", path: "gallery.a", value: protoreflect.ValueOfString(""), wantErr: ErrInvalidIndex},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &testproto.Profile{Gallery: []*testproto.Photo{{PhotoId: 1}, {PhotoId: 2}}}
+			if _, err := Get(msg, tt.path); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+			if err := Set(msg, tt.path, tt.value); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Set() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	var syntaxErr *SyntaxError
+	if _, err := Get(&testproto.Profile{}, "user..name"); !errors.As(err, &syntaxErr) {
+		t.Errorf("Get() error = %v, want a *SyntaxError", err)
+	}
+
+	leaderboard := &testproto.Leaderboard{}
+	if err := Set(leaderboard, "labels.a", protoreflect.ValueOfString("")); !errors.Is(err, ErrInvalidMapKey) {
+		t.Errorf("Set() error = %v, want %v", err, ErrInvalidMapKey)
+	}
+
+	leaderboard = &testproto.Leaderboard{}
+	for _, scores := range []map[int32]*testproto.Score{{}, {1: {}}} {
+		src := (&testproto.Leaderboard{Int32Scores: scores}).ProtoReflect()
+		v := src.Mutable(src.Descriptor().Fields().ByName("int32_scores"))
+		if err := Set(leaderboard, "scores", v); err == nil {
+			t.Errorf("Set(%q) error = nil, want an error for a map with int32 keys", "scores")
+		}
+	}
+	if !proto.Equal(leaderboard, &testproto.Leaderboard{}) {
+		t.Errorf("msg %v, want %v", leaderboard, &testproto.Leaderboard{})
+	}
+
+	msg := &testproto.Profile{User: &testproto.User{UserId: 1}}
+	wrongValues := map[string]protoreflect.Value{
+		"user.user_id":       protoreflect.ValueOfInt32(2),
+		"user":               protoreflect.ValueOfMessage((&testproto.Photo{}).ProtoReflect()),
+		"login_timestamps":   protoreflect.ValueOfInt64(1),
+		"photo.dimensions":   protoreflect.ValueOfString(""),
+		"attributes.a1.tags": protoreflect.ValueOfString(""),
+	}
+	for path, v := range wrongValues {
+		if err := Set(msg, path, v); err == nil {
+			t.Errorf("Set(%q) error = nil, want an error for a value of a wrong type", path)
+		}
+	}
+	if want := (&testproto.Profile{User: &testproto.User{UserId: 1}}); !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
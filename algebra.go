@@ -0,0 +1,281 @@
+package fmutils
+
+// Union returns a new mask that contains all the paths from both mask and other.
+//
+// A field that is listed in one of the masks as a whole (without subfields) is listed as a whole in the result, the
+// keys that are covered by the wildcard are dropped.
+// Note that the set operations treat an empty mask as an empty set of paths unlike NestedMask.Filter which keeps all
+// the fields for an empty mask.
+// A path excluded from one of the masks stays excluded unless the other mask covers it. If the other mask covers only
+// some of its subfields, then the exclusion is dropped, so the result may cover more than both masks.
+func (mask NestedMask) Union(other NestedMask) NestedMask {
+	if mask.hasExclusions() || other.hasExclusions() {
+		ia, xa := mask.split()
+		ib, xb := other.split()
+		excludes := xa.Intersect(xb).Union(xa.difference(ib)).Union(xb.difference(ia))
+		return ia.Union(ib).withExclusions(excludes)
+	}
+	result := mask.clone()
+	for key, o := range other {
+		m, ok := result[key]
+		switch {
+		case !ok:
+			result[key] = o.clone()
+		case len(m) == 0 || len(o) == 0:
+			result[key] = NestedMask{}
+		default:
+			result[key] = m.Union(o)
+		}
+	}
+	return result.withoutCovered()
+}
+
+// Intersect returns a new mask that contains only the paths that are covered by both mask and other.
+//
+// A field that is listed in one of the masks is also covered by the wildcard of the other mask. The paths excluded from
+// any of the masks are excluded from the result.
+// The result is empty if the masks have no paths in common. NestedMask.Filter keeps all the fields for an empty mask,
+// so check the result for emptiness before filtering with it, e.g. when limiting the requested fields to the allowed
+// ones, otherwise the whole message is kept.
+func (mask NestedMask) Intersect(other NestedMask) NestedMask {
+	if mask.hasExclusions() || other.hasExclusions() {
+		ia, xa := mask.split()
+		ib, xb := other.split()
+		return ia.Intersect(ib).withExclusions(xa.Union(xb))
+	}
+	result := make(NestedMask)
+	intersect := func(key string) {
+		m, ok := mask.match(key)
+		if !ok {
+			return
+		}
+		o, ok := other.match(key)
+		switch {
+		case !ok:
+		case len(m) == 0:
+			result[key] = o.clone()
+		case len(o) == 0:
+			result[key] = m.clone()
+		default:
+			if i := m.Intersect(o); len(i) != 0 {
+				result[key] = i
+			}
+		}
+	}
+	for key := range mask {
+		intersect(key)
+	}
+	for key := range other {
+		if _, ok := mask[key]; !ok {
+			intersect(key)
+		}
+	}
+	return result
+}
+
+// Subtract returns a new mask that contains the paths from the mask that are not covered by other.
+//
+// A field that is listed in the mask as a whole is removed from the result entirely if other lists only some of its
+// subfields. The same applies to a wildcard in the mask if other lists some of the fields it matches. This keeps the
+// result within the difference without adding exclusions to it, so it can still be sent downstream as a FieldMask,
+// which is what is expected when removing forbidden fields. Use NestedMask.Without to keep such fields with the paths
+// of other excluded from them.
+// The exclusions of other are not taken into account, the exclusions of the mask are kept in the result.
+func (mask NestedMask) Subtract(other NestedMask) NestedMask {
+	includes, excludes := mask.split()
+	others, _ := other.split()
+	return includes.difference(others).withExclusions(excludes)
+}
+
+// Without returns a new mask with the paths of other excluded from the paths of the mask.
+//
+// Unlike NestedMask.Subtract a field that other covers only partially is kept with the paths of other excluded from
+// it, e.g. "user" without "user.name" is "user" with "-user.name" excluded, while the fields that are covered by other
+// entirely are removed. The result can not be converted to a FieldMask if it has exclusions.
+// The exclusions of other are not taken into account, so the result is always within the difference.
+func (mask NestedMask) Without(other NestedMask) NestedMask {
+	includes, excludes := mask.split()
+	others, _ := other.split()
+	return includes.withExclusions(excludes.Union(others))
+}
+
+// difference returns the paths from the mask that are not covered by other at all: a field that other covers only
+// partially is removed entirely, the same applies to a wildcard in the mask if other lists some of the fields it
+// matches.
+func (mask NestedMask) difference(other NestedMask) NestedMask {
+	result := make(NestedMask)
+	for key, m := range mask {
+		if key == wildcard && other.overlapsAnyKey(m) {
+			continue
+		}
+		o, ok := other.match(key)
+		switch {
+		case !ok:
+			result[key] = m.clone()
+		case len(o) == 0 || len(m) == 0:
+		default:
+			if s := m.difference(o); len(s) != 0 {
+				result[key] = s
+			}
+		}
+	}
+	return result
+}
+
+// IsSubsetOf reports whether all the paths in the mask are covered by other.
+//
+// An empty mask is a subset of any mask. The exclusions of the mask are not taken into account when its paths are
+// compared with the paths of other, so the result may be false for a mask which paths are covered by other only
+// because of the exclusions.
+func (mask NestedMask) IsSubsetOf(other NestedMask) bool {
+	if mask.hasExclusions() || other.hasExclusions() {
+		ia, xa := mask.split()
+		ib, xb := other.split()
+		return ia.IsSubsetOf(ib) && xb.Intersect(ia).IsSubsetOf(xa)
+	}
+	for key, m := range mask {
+		o, ok := other.match(key)
+		if !ok {
+			return false
+		}
+		if len(o) == 0 {
+			continue
+		}
+		if len(m) == 0 || !m.IsSubsetOf(o) {
+			return false
+		}
+	}
+	return true
+}
+
+// Overlaps reports whether there is at least one path covered by both mask and other.
+//
+// An empty mask does not overlap with any mask.
+func (mask NestedMask) Overlaps(other NestedMask) bool {
+	if mask.hasExclusions() || other.hasExclusions() {
+		includes, excludes := mask.Intersect(other).split()
+		return len(includes) != 0 && !includes.IsSubsetOf(excludes)
+	}
+	for key, m := range mask {
+		if key == wildcard {
+			if other.overlapsAnyField(m) {
+				return true
+			}
+			continue
+		}
+		if o, ok := other.child(key); ok && overlaps(m, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the mask that covers the given key of another mask.
+//
+// Unlike NestedMask.child the wildcard key is matched only by the wildcard since the other keys do not cover all the
+// fields.
+func (mask NestedMask) match(key string) (NestedMask, bool) {
+	if key == wildcard {
+		m, ok := mask[wildcard]
+		return m, ok
+	}
+	return mask.child(key)
+}
+
+// overlapsAnyField reports whether the mask of any of the fields overlaps with the given mask of a single field.
+func (mask NestedMask) overlapsAnyField(m NestedMask) bool {
+	for _, o := range mask {
+		if overlaps(m, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlapsAnyKey reports whether the mask of any of the fields except the wildcard overlaps with the given mask of
+// a single field.
+func (mask NestedMask) overlapsAnyKey(m NestedMask) bool {
+	for key, o := range mask {
+		if key != wildcard && overlaps(m, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether the masks of a single field overlap, where an empty mask means the entire field.
+func overlaps(m, o NestedMask) bool {
+	return len(m) == 0 || len(o) == 0 || m.Overlaps(o)
+}
+
+// hasExclusions reports whether the mask has excluded paths.
+func (mask NestedMask) hasExclusions() bool {
+	_, ok := mask[exclusion]
+	return ok
+}
+
+// withExclusions returns the mask with the given paths excluded from it.
+//
+// The paths of the mask excluded as a whole are removed from it and only the exclusions within the mask are kept, so
+// that the result is normalized.
+func (mask NestedMask) withExclusions(excludes NestedMask) NestedMask {
+	result := mask.without(excludes)
+	if w, ok := result[wildcard]; ok && len(w) == 0 {
+		result = NestedMask{wildcard: w}
+	}
+	if excludes = excludes.Intersect(result); len(excludes) != 0 {
+		result[exclusion] = excludes
+	}
+	return result
+}
+
+// without returns a copy of the mask without the paths that are covered by excludes entirely.
+func (mask NestedMask) without(excludes NestedMask) NestedMask {
+	result := make(NestedMask, len(mask))
+	for key, m := range mask {
+		x, ok := excludes.match(key)
+		switch {
+		case !ok:
+			result[key] = m.clone()
+		case len(x) == 0:
+		case len(m) == 0:
+			result[key] = NestedMask{}
+		default:
+			if w := m.without(x); len(w) != 0 {
+				result[key] = w
+			}
+		}
+	}
+	return result
+}
+
+// withoutCovered removes the keys that are covered by the wildcard entirely at every level of the mask in place and
+// returns the mask.
+func (mask NestedMask) withoutCovered() NestedMask {
+	w, hasWildcard := mask[wildcard]
+	for key, m := range mask {
+		if hasWildcard && key != wildcard && (len(w) == 0 || len(m) != 0 && m.IsSubsetOf(w)) {
+			delete(mask, key)
+			continue
+		}
+		m.withoutCovered()
+	}
+	return mask
+}
+
+// unionChild sets the mask of the key to the union of its current mask and m.
+func (mask NestedMask) unionChild(key string, m NestedMask) {
+	if r, ok := mask[key]; ok {
+		m = NestedMask{key: r}.Union(NestedMask{key: m})[key]
+	}
+	mask[key] = m
+}
+
+// clone returns a deep copy of the mask.
+func (mask NestedMask) clone() NestedMask {
+	result := make(NestedMask, len(mask))
+	for key, m := range mask {
+		result[key] = m.clone()
+	}
+	return result
+}
@@ -0,0 +1,352 @@
+package fmutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNestedMask_Union(t *testing.T) {
+	tests := []struct {
+		name  string
+		mask  []string
+		other []string
+		want  NestedMask
+	}{
+		{
+			name:  "empty masks",
+			mask:  []string{},
+			other: []string{},
+			want:  NestedMask{},
+		},
+		{
+			name:  "disjoint masks",
+			mask:  []string{"a.b", "c"},
+			other: []string{"a.c", "d"},
+			want:  NestedMask{"a": NestedMask{"b": NestedMask{}, "c": NestedMask{}}, "c": NestedMask{}, "d": NestedMask{}},
+		},
+		{
+			name:  "whole subtree absorbs subfields",
+			mask:  []string{"a.b.c", "d.e"},
+			other: []string{"a", "d.e.f"},
+			want:  NestedMask{"a": NestedMask{}, "d": NestedMask{"e": NestedMask{}}},
+		},
+		{
+			name:  "wildcard is combined with the keys",
+			mask:  []string{"a.*.b"},
+			other: []string{"a.*.c", "a.d"},
+			want:  NestedMask{"a": NestedMask{"*": NestedMask{"b": NestedMask{}, "c": NestedMask{}}, "d": NestedMask{}}},
+		},
+		{
+			name:  "whole wildcard absorbs the keys",
+			mask:  []string{"*"},
+			other: []string{"a.b"},
+			want:  NestedMask{"*": NestedMask{}},
+		},
+		{
+			name:  "keys covered by the wildcard are dropped",
+			mask:  []string{"attributes.*"},
+			other: []string{"attributes.a1.tags", "photos.*.path", "photos.p1.path", "photos.p2"},
+			want: NestedMask{
+				"attributes": NestedMask{"*": NestedMask{}},
+				"photos":     NestedMask{"*": NestedMask{"path": NestedMask{}}, "p2": NestedMask{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, other := NestedMaskFromPaths(tt.mask), NestedMaskFromPaths(tt.other)
+			if got := mask.Union(other); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Union() = %v, want %v", got, tt.want)
+			}
+			if got := other.Union(mask); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Union() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(mask, NestedMaskFromPaths(tt.mask)) {
+				t.Errorf("Union() modified the mask %v", mask)
+			}
+		})
+	}
+}
+
+func TestNestedMask_Intersect(t *testing.T) {
+	tests := []struct {
+		name  string
+		mask  []string
+		other []string
+		want  NestedMask
+	}{
+		{
+			name:  "empty mask",
+			mask:  []string{},
+			other: []string{"a"},
+			want:  NestedMask{},
+		},
+		{
+			name:  "disjoint masks",
+			mask:  []string{"a.b", "c"},
+			other: []string{"a.c", "d"},
+			want:  NestedMask{},
+		},
+		{
+			name:  "whole subtree is narrowed down",
+			mask:  []string{"a", "d.e", "f"},
+			other: []string{"a.b.c", "d", "g"},
+			want:  NestedMask{"a": NestedMask{"b": NestedMask{"c": NestedMask{}}}, "d": NestedMask{"e": NestedMask{}}},
+		},
+		{
+			name:  "common subfields",
+			mask:  []string{"a.b", "a.c"},
+			other: []string{"a.c", "a.d"},
+			want:  NestedMask{"a": NestedMask{"c": NestedMask{}}},
+		},
+		{
+			name:  "wildcard covers the keys",
+			mask:  []string{"a.*.b", "c.*"},
+			other: []string{"a.d", "a.e.c", "c.*.f"},
+			want: NestedMask{
+				"a": NestedMask{"d": NestedMask{"b": NestedMask{}}},
+				"c": NestedMask{"*": NestedMask{"f": NestedMask{}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, other := NestedMaskFromPaths(tt.mask), NestedMaskFromPaths(tt.other)
+			if got := mask.Intersect(other); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Intersect() = %v, want %v", got, tt.want)
+			}
+			if got := other.Intersect(mask); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Intersect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNestedMask_Subtract(t *testing.T) {
+	tests := []struct {
+		name  string
+		mask  []string
+		other []string
+		want  NestedMask
+	}{
+		{
+			name:  "empty other",
+			mask:  []string{"a.b", "c"},
+			other: []string{},
+			want:  NestedMask{"a": NestedMask{"b": NestedMask{}}, "c": NestedMask{}},
+		},
+		{
+			name:  "whole subtree is removed",
+			mask:  []string{"a.b", "c"},
+			other: []string{"a"},
+			want:  NestedMask{"c": NestedMask{}},
+		},
+		{
+			name:  "subfields are removed",
+			mask:  []string{"a.b", "a.c.d", "a.c.e"},
+			other: []string{"a.b", "a.c.d"},
+			want:  NestedMask{"a": NestedMask{"c": NestedMask{"e": NestedMask{}}}},
+		},
+		{
+			name:  "partially removed whole subtree is removed",
+			mask:  []string{"a", "c"},
+			other: []string{"a.b"},
+			want:  NestedMask{"c": NestedMask{}},
+		},
+		{
+			name:  "exclusions of the mask are kept",
+			mask:  []string{"a", "c", "-c.d"},
+			other: []string{"a.b"},
+			want:  NestedMask{"c": NestedMask{}, exclusion: NestedMask{"c": NestedMask{"d": NestedMask{}}}},
+		},
+		{
+			name:  "key removes the whole wildcard",
+			mask:  []string{"*"},
+			other: []string{"a"},
+			want:  NestedMask{},
+		},
+		{
+			name:  "wildcard removes the keys",
+			mask:  []string{"a.b.c", "a.d.e", "f"},
+			other: []string{"a.*.c"},
+			want:  NestedMask{"a": NestedMask{"d": NestedMask{"e": NestedMask{}}}, "f": NestedMask{}},
+		},
+		{
+			name:  "partially removed wildcard is removed",
+			mask:  []string{"a.*", "f"},
+			other: []string{"a.b"},
+			want:  NestedMask{"f": NestedMask{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, other := NestedMaskFromPaths(tt.mask), NestedMaskFromPaths(tt.other)
+			if got := mask.Subtract(other); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Subtract() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNestedMask_Without(t *testing.T) {
+	tests := []struct {
+		name  string
+		mask  []string
+		other []string
+		want  NestedMask
+	}{
+		{
+			name:  "whole subtree is removed",
+			mask:  []string{"a.b", "c"},
+			other: []string{"a"},
+			want:  NestedMask{"c": NestedMask{}},
+		},
+		{
+			name:  "partially removed whole subtree gets an exclusion",
+			mask:  []string{"a", "c"},
+			other: []string{"a.b"},
+			want:  NestedMask{"a": NestedMask{}, "c": NestedMask{}, exclusion: NestedMask{"a": NestedMask{"b": NestedMask{}}}},
+		},
+		{
+			name:  "exclusions do not depend on the unrelated keys",
+			mask:  []string{"a", "-c"},
+			other: []string{"a.b"},
+			want:  NestedMask{"a": NestedMask{}, exclusion: NestedMask{"a": NestedMask{"b": NestedMask{}}}},
+		},
+		{
+			name:  "key removed from the whole wildcard",
+			mask:  []string{"*"},
+			other: []string{"a"},
+			want:  NestedMask{"*": NestedMask{}, exclusion: NestedMask{"a": NestedMask{}}},
+		},
+		{
+			name:  "partially removed wildcard gets an exclusion",
+			mask:  []string{"a.*", "f"},
+			other: []string{"a.b"},
+			want: NestedMask{
+				"a":       NestedMask{"*": NestedMask{}},
+				"f":       NestedMask{},
+				exclusion: NestedMask{"a": NestedMask{"b": NestedMask{}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, other := NestedMaskFromPaths(tt.mask), NestedMaskFromPaths(tt.other)
+			if got := mask.Without(other); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Without() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNestedMask_IsSubsetOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		mask  []string
+		other []string
+		want  bool
+	}{
+		{
+			name:  "empty mask",
+			mask:  []string{},
+			other: []string{},
+			want:  true,
+		},
+		{
+			name:  "subfields of a whole subtree",
+			mask:  []string{"a.b.c", "a.d", "e"},
+			other: []string{"a", "e"},
+			want:  true,
+		},
+		{
+			name:  "whole subtree of subfields",
+			mask:  []string{"a"},
+			other: []string{"a.b"},
+			want:  false,
+		},
+		{
+			name:  "missing field",
+			mask:  []string{"a.b", "c"},
+			other: []string{"a.b"},
+			want:  false,
+		},
+		{
+			name:  "keys of a wildcard",
+			mask:  []string{"a.b.c", "a.d"},
+			other: []string{"a.*"},
+			want:  true,
+		},
+		{
+			name:  "wildcard of keys",
+			mask:  []string{"a.*"},
+			other: []string{"a.b", "a.c"},
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, other := NestedMaskFromPaths(tt.mask), NestedMaskFromPaths(tt.other)
+			if got := mask.IsSubsetOf(other); got != tt.want {
+				t.Errorf("IsSubsetOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNestedMask_Overlaps(t *testing.T) {
+	tests := []struct {
+		name  string
+		mask  []string
+		other []string
+		want  bool
+	}{
+		{
+			name:  "empty mask",
+			mask:  []string{},
+			other: []string{"a"},
+			want:  false,
+		},
+		{
+			name:  "disjoint subfields",
+			mask:  []string{"a.b", "c.d"},
+			other: []string{"a.c", "d"},
+			want:  false,
+		},
+		{
+			name:  "whole subtree",
+			mask:  []string{"a.b.c"},
+			other: []string{"a"},
+			want:  true,
+		},
+		{
+			name:  "common subfield",
+			mask:  []string{"a.b.c", "a.d"},
+			other: []string{"a.b.c"},
+			want:  true,
+		},
+		{
+			name:  "wildcard and a key",
+			mask:  []string{"a.*.b"},
+			other: []string{"a.c.b"},
+			want:  true,
+		},
+		{
+			name:  "disjoint wildcards",
+			mask:  []string{"a.*.b"},
+			other: []string{"a.*.c", "a.d.e"},
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, other := NestedMaskFromPaths(tt.mask), NestedMaskFromPaths(tt.other)
+			if got := mask.Overlaps(other); got != tt.want {
+				t.Errorf("Overlaps() = %v, want %v", got, tt.want)
+			}
+			if got := other.Overlaps(mask); got != tt.want {
+				t.Errorf("Overlaps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package fmutils
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	anyFullName        protoreflect.FullName    = "google.protobuf.Any"
+	anyTypeURLFieldNum protoreflect.FieldNumber = 1
+	anyValueFieldNum   protoreflect.FieldNumber = 2
+)
+
+// resolvesAny reports whether the messages of the given type are unpacked before applying a mask.
+func (o *options) resolvesAny(md protoreflect.MessageDescriptor) bool {
+	return o.anyResolver != nil && md.FullName() == anyFullName
+}
+
+// unpackAny returns the message packed into the given google.protobuf.Any message.
+func (o *options) unpackAny(m protoreflect.Message) (protoreflect.Message, error) {
+	fields := m.Descriptor().Fields()
+	url := m.Get(fields.ByNumber(anyTypeURLFieldNum)).String()
+	mt, err := o.anyResolver.FindMessageByURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("fmutils: can not resolve google.protobuf.Any type %q: %w", url, err)
+	}
+	packed := mt.New()
+	if err := proto.Unmarshal(m.Get(fields.ByNumber(anyValueFieldNum)).Bytes(), packed.Interface()); err != nil {
+		return nil, fmt.Errorf("fmutils: can not unpack google.protobuf.Any type %q: %w", url, err)
+	}
+	return packed, nil
+}
+
+// validateAny validates the mask against the type of the packed message if the options are checked.
+func (o *options) validateAny(packed protoreflect.Message, mask NestedMask) error {
+	if !o.checked {
+		return nil
+	}
+	if err := mask.Validate(packed.Descriptor(), WithAnyResolver(o.anyResolver)); err != nil {
+		return fmt.Errorf("fmutils: invalid mask for google.protobuf.Any type %s: %w", packed.Descriptor().FullName(), err)
+	}
+	return nil
+}
+
+// packAny packs the message into the given google.protobuf.Any message.
+func packAny(m protoreflect.Message, url string, packed protoreflect.Message) error {
+	b, err := proto.Marshal(packed.Interface())
+	if err != nil {
+		return fmt.Errorf("fmutils: can not pack google.protobuf.Any type %q: %w", url, err)
+	}
+	fields := m.Descriptor().Fields()
+	m.Set(fields.ByNumber(anyTypeURLFieldNum), protoreflect.ValueOfString(url))
+	m.Set(fields.ByNumber(anyValueFieldNum), protoreflect.ValueOfBytes(b))
+	return nil
+}
+
+// applyToAny applies the mask to the message packed into the given google.protobuf.Any message in place.
+//
+// If the packed message can not be unpacked then the Any message is reset and recorded as cleared.
+func (o *options) applyToAny(m protoreflect.Message, mask NestedMask,
+	apply func(NestedMask, protoreflect.Message, *options) error) error {
+	packed, err := o.unpackAny(m)
+	if err != nil {
+		proto.Reset(m.Interface())
+		if o.report != nil {
+			o.report.Fields++
+			o.record()
+		}
+		return err
+	}
+	mask = o.protoNames(mask, packed.Descriptor())
+	if err := o.validateAny(packed, mask); err != nil {
+		return err
+	}
+	err = apply(mask, packed, o)
+	url := m.Get(m.Descriptor().Fields().ByNumber(anyTypeURLFieldNum)).String()
+	return firstError(err, packAny(m, url, packed))
+}
+
+// copyAny applies the mask to the message packed into the src google.protobuf.Any message and packs the result into
+// the dst Any message.
+//
+// If the packed message can not be unpacked then dst is left empty.
+func (o *options) copyAny(dst, src protoreflect.Message, mask NestedMask,
+	apply func(NestedMask, protoreflect.Message, protoreflect.Message, *options) error) error {
+	packed, err := o.unpackAny(src)
+	if err == nil {
+		mask = o.protoNames(mask, packed.Descriptor())
+		err = o.validateAny(packed, mask)
+	}
+	if err != nil {
+		return err
+	}
+	result := packed.New()
+	err = apply(mask, result, packed, o)
+	url := src.Get(src.Descriptor().Fields().ByNumber(anyTypeURLFieldNum)).String()
+	return firstError(err, packAny(dst, url, result))
+}
+
+// firstError returns err if it is not nil, otherwise next.
+func firstError(err, next error) error {
+	if err != nil {
+		return err
+	}
+	return next
+}
@@ -0,0 +1,205 @@
+package fmutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestFilter_WithAnyResolver(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		msg   proto.Message
+		want  proto.Message
+	}{
+		{
+			name:  "mask with Any field keeps the entire Any field",
+			paths: []string{"details"},
+			msg: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+			},
+			want: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+			},
+		},
+		{
+			name:  "mask with Any subfields filters the packed message",
+			paths: []string{"event_id", "details.data"},
+			msg: &testproto.Event{
+				EventId: 1,
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+			},
+			want: &testproto.Event{
+				EventId: 1,
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes")})},
+			},
+		},
+		{
+			name:  "mask with nested Any subfields filters the packed message",
+			paths: []string{"details.photo.dimensions.width"},
+			msg: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Event{
+					EventId: 1,
+					Changed: &testproto.Event_Photo{Photo: &testproto.Photo{
+						PhotoId:    1,
+						Dimensions: &testproto.Dimensions{Width: 100, Height: 120},
+					}},
+				})},
+			},
+			want: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Event{
+					Changed: &testproto.Event_Photo{Photo: &testproto.Photo{
+						Dimensions: &testproto.Dimensions{Width: 100},
+					}},
+				})},
+			},
+		},
+		{
+			name:  "unresolvable Any field is reset",
+			paths: []string{"details.data"},
+			msg: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: &anypb.Any{TypeUrl: "type.googleapis.com/unknown.Type"}},
+			},
+			want: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: &anypb.Any{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Filter(tt.msg, tt.paths, WithAnyResolver(protoregistry.GlobalTypes))
+			if !proto.Equal(tt.msg, tt.want) {
+				t.Errorf("msg %v, want %v", tt.msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrune_WithAnyResolver(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		msg   proto.Message
+		want  proto.Message
+	}{
+		{
+			name:  "mask with Any field clears the entire Any field",
+			paths: []string{"details"},
+			msg: &testproto.Event{
+				EventId: 1,
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+			},
+			want: &testproto.Event{
+				EventId: 1,
+			},
+		},
+		{
+			name:  "mask with Any subfields prunes the packed message",
+			paths: []string{"event_id", "details.data"},
+			msg: &testproto.Event{
+				EventId: 1,
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+			},
+			want: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{NextToken: 1})},
+			},
+		},
+		{
+			name:  "mask with Any subfields in a list prunes the packed messages",
+			paths: []string{"details.gallery.path"},
+			msg: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Profile{
+					Gallery: []*testproto.Photo{{PhotoId: 1, Path: "path 1"}, {PhotoId: 2, Path: "path 2"}},
+				})},
+			},
+			want: &testproto.Event{
+				Changed: &testproto.Event_Details{Details: createAny(&testproto.Profile{
+					Gallery: []*testproto.Photo{{PhotoId: 1}, {PhotoId: 2}},
+				})},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Prune(tt.msg, tt.paths, WithAnyResolver(protoregistry.GlobalTypes))
+			if !proto.Equal(tt.msg, tt.want) {
+				t.Errorf("msg %v, want %v", tt.msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneReport_unresolvedAny(t *testing.T) {
+	msg := &testproto.Event{
+		EventId: 1,
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+	}
+	got := PruneReport(msg, []string{"details.data"}, WithAnyResolver(new(protoregistry.Types)))
+	if want := (Report{Paths: []string{"details"}, Fields: 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("PruneReport() = %+v, want %+v", got, want)
+	}
+	want := &testproto.Event{EventId: 1, Changed: &testproto.Event_Details{Details: &anypb.Any{}}}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
+
+func TestFilterCopy_WithAnyResolver(t *testing.T) {
+	msg := &testproto.Event{
+		EventId: 1,
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+	}
+	orig := proto.Clone(msg)
+	want := &testproto.Event{
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{NextToken: 1})},
+	}
+	if got := FilterCopy(msg, []string{"details.next_token"}, WithAnyResolver(protoregistry.GlobalTypes)); !proto.Equal(got, want) {
+		t.Errorf("FilterCopy() = %v, want %v", got, want)
+	}
+	want = &testproto.Event{
+		EventId: 1,
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes")})},
+	}
+	if got := PruneCopy(msg, []string{"details.next_token"}, WithAnyResolver(protoregistry.GlobalTypes)); !proto.Equal(got, want) {
+		t.Errorf("PruneCopy() = %v, want %v", got, want)
+	}
+	if !proto.Equal(msg, orig) {
+		t.Errorf("msg %v, want %v", msg, orig)
+	}
+}
+
+func TestFilterChecked_WithAnyResolver(t *testing.T) {
+	msg := &testproto.Event{
+		Changed: &testproto.Event_Details{Details: &anypb.Any{TypeUrl: "type.googleapis.com/unknown.Type"}},
+	}
+	err := FilterChecked(msg, []string{"details.data"}, WithAnyResolver(protoregistry.GlobalTypes))
+	if !errors.Is(err, protoregistry.NotFound) {
+		t.Errorf("FilterChecked() error = %v, want %v", err, protoregistry.NotFound)
+	}
+
+	msg = &testproto.Event{
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+	}
+	err = PruneChecked(msg, []string{"details.unknown"}, WithAnyResolver(protoregistry.GlobalTypes))
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("PruneChecked() error = %v, want %v", err, ErrUnknownField)
+	}
+
+	err = PruneChecked(msg, []string{"details.data"}, WithAnyResolver(protoregistry.GlobalTypes))
+	if err != nil {
+		t.Errorf("PruneChecked() error = %v, want nil", err)
+	}
+	want := &testproto.Event{
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{NextToken: 1})},
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
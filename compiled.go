@@ -0,0 +1,243 @@
+package fmutils
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// CompiledMask is a NestedMask resolved against a message descriptor.
+//
+// The field names and map keys are resolved once when the mask is compiled, so applying a CompiledMask does not look
+// up the fields by their names. Use it when the same mask is applied to many messages of the same type.
+// A CompiledMask is safe for concurrent use.
+type CompiledMask struct {
+	md   protoreflect.MessageDescriptor
+	root *compiledMessage
+}
+
+// compiledMessage is the mask of a message.
+type compiledMessage struct {
+	// mask is the mask of the message the compiledMessage is created from.
+	mask NestedMask
+	// fields holds the masks of the fields indexed by protoreflect.FieldDescriptor.Index, nil for the fields that are
+	// not listed in the mask.
+	fields []*compiledField
+}
+
+// compiledField is the mask of a field, a list element or a map value.
+type compiledField struct {
+	// mask is the mask of the field the compiledField is created from, empty if the field is listed as a whole.
+	mask NestedMask
+	// message is the mask of a message value, nil if the field is listed as a whole or is not a message.
+	message *compiledMessage
+	// keys holds the masks of the map entries by protoreflect.MapKey.Interface, nil for the excluded entries.
+	keys map[interface{}]*compiledField
+	// anyKey is the mask of the map entries that are not listed in keys, nil if the mask has no wildcard.
+	anyKey *compiledField
+	// indexed is set for a repeated field which elements are listed by their indices, such a mask is applied as a
+	// NestedMask since the indices depend on the length of the list.
+	indexed bool
+}
+
+// Compile resolves the mask against the given message descriptor.
+//
+// The paths that do not match the descriptor are ignored like NestedMask.Filter and NestedMask.Prune do, use
+// NestedMask.Validate to detect them. The WithJSONNames option is applied when the mask is compiled, the other options
+// are given when the mask is applied.
+func (mask NestedMask) Compile(md protoreflect.MessageDescriptor, opts ...Option) *CompiledMask {
+	return &CompiledMask{md: md, root: compileMessage(newOptions(opts).protoNames(mask, md), md)}
+}
+
+func compileMessage(mask NestedMask, md protoreflect.MessageDescriptor) *compiledMessage {
+	c := &compiledMessage{mask: mask}
+	if len(mask) == 0 {
+		return c
+	}
+	fields := md.Fields()
+	c.fields = make([]*compiledField, fields.Len())
+	for i := range c.fields {
+		fd := fields.Get(i)
+		if m, ok := mask.child(string(fd.Name())); ok {
+			c.fields[i] = compileField(m, fd)
+		}
+	}
+	return c
+}
+
+func compileField(mask NestedMask, fd protoreflect.FieldDescriptor) *compiledField {
+	f := &compiledField{mask: mask}
+	switch {
+	case len(mask) == 0:
+	case fd.IsMap():
+		mask = mask.withMapKeys(fd.MapKey())
+		includes, excludes := mask.split()
+		f.keys = make(map[interface{}]*compiledField, len(includes)+len(excludes))
+		for _, keys := range [2]NestedMask{includes, excludes} {
+			for key := range keys {
+				if key == wildcard {
+					continue
+				}
+				// The keys are in the canonical form, so they always parse.
+				mk, _ := parseMapKey(fd.MapKey(), key)
+				if m, ok := mask.child(key); ok {
+					f.keys[mk.Interface()] = compileField(m, fd.MapValue())
+				} else {
+					// The key is excluded.
+					f.keys[mk.Interface()] = nil
+				}
+			}
+		}
+		if m, ok := mask.child(wildcard); ok {
+			f.anyKey = compileField(m, fd.MapValue())
+		}
+	case fd.IsList() && mask.listMask(fd).indexed():
+		f.indexed = true
+	case fd.Message() != nil:
+		f.message = compileMessage(mask, fd.Message())
+	}
+	return f
+}
+
+// Descriptor returns the descriptor of the messages the mask is compiled for.
+func (c *CompiledMask) Descriptor() protoreflect.MessageDescriptor {
+	return c.md
+}
+
+// Filter keeps the msg fields that are listed in the mask and clears all the rest.
+//
+// It is equivalent to NestedMask.Filter. The msg must be of the type the mask is compiled for, otherwise the method
+// panics.
+func (c *CompiledMask) Filter(msg proto.Message, opts ...Option) {
+	_ = c.root.filter(c.message(msg), newOptions(opts))
+}
+
+// Prune clears all the fields listed in the mask from the given msg.
+//
+// It is equivalent to NestedMask.Prune. The msg must be of the type the mask is compiled for, otherwise the method
+// panics.
+func (c *CompiledMask) Prune(msg proto.Message, opts ...Option) {
+	_ = c.root.prune(c.message(msg), newOptions(opts))
+}
+
+func (c *CompiledMask) message(msg proto.Message) protoreflect.Message {
+	rft := msg.ProtoReflect()
+	if rft.Descriptor() != c.md {
+		panic(fmt.Sprintf("fmutils: mask compiled for %s can not be applied to %s", c.md.FullName(),
+			rft.Descriptor().FullName()))
+	}
+	return rft
+}
+
+// field returns the mask of the given field, nil if the field is not listed in the mask.
+func (c *compiledMessage) field(fd protoreflect.FieldDescriptor) *compiledField {
+	if fd.IsExtension() {
+		// Extensions are not known at compile time.
+		if m, ok := c.mask.child(string(fd.Name())); ok {
+			return compileField(m, fd)
+		}
+		return nil
+	}
+	return c.fields[fd.Index()]
+}
+
+// entry returns the mask of the given map entry, nil if the entry is not listed in the mask.
+func (f *compiledField) entry(mk protoreflect.MapKey) *compiledField {
+	if e, ok := f.keys[mk.Interface()]; ok {
+		return e
+	}
+	return f.anyKey
+}
+
+func (c *compiledMessage) filter(rft protoreflect.Message, o *options) error {
+	if len(c.mask) == 0 {
+		return nil
+	}
+
+	var err error
+	o.filterUnknown(rft)
+	rft.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		f := c.field(fd)
+		switch {
+		case f == nil:
+			rft.Clear(fd)
+		case len(f.mask) == 0:
+		case fd.IsMap():
+			xmap := v.Map()
+			xmap.Range(func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
+				e := f.entry(mk)
+				if e == nil || e.message != nil && o.apply(mv.Message(), e.filterMessage, &err) {
+					xmap.Clear(mk)
+				}
+				return true
+			})
+		case f.indexed:
+			err = firstError(err, f.mask.filterList(fd, rft.Mutable(fd).List(), o))
+		case f.message == nil:
+		case fd.IsList():
+			removeElements(rft.Mutable(fd).List(), func(e protoreflect.Value) bool {
+				return o.apply(e.Message(), f.filterMessage, &err)
+			})
+		case o.apply(v.Message(), f.filterMessage, &err):
+			rft.Clear(fd)
+		}
+		return true
+	})
+	return err
+}
+
+// filterMessage filters the message which is a singular field value, a list element or a map value.
+func (f *compiledField) filterMessage(m protoreflect.Message, o *options) error {
+	if o.resolvesAny(m.Descriptor()) {
+		return o.applyToAny(m, f.mask, NestedMask.filter)
+	}
+	return f.message.filter(m, o)
+}
+
+func (c *compiledMessage) prune(rft protoreflect.Message, o *options) error {
+	if len(c.mask) == 0 {
+		return nil
+	}
+
+	var err error
+	rft.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		f := c.field(fd)
+		switch {
+		case f == nil:
+		case len(f.mask) == 0:
+			rft.Clear(fd)
+		case fd.IsMap():
+			xmap := v.Map()
+			xmap.Range(func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
+				e := f.entry(mk)
+				if e == nil {
+					return true
+				}
+				if e.message == nil || o.apply(mv.Message(), e.pruneMessage, &err) {
+					xmap.Clear(mk)
+				}
+				return true
+			})
+		case f.indexed:
+			err = firstError(err, f.mask.pruneList(fd, rft.Mutable(fd).List(), o))
+		case f.message == nil:
+		case fd.IsList():
+			removeElements(rft.Mutable(fd).List(), func(e protoreflect.Value) bool {
+				return o.apply(e.Message(), f.pruneMessage, &err)
+			})
+		case o.apply(v.Message(), f.pruneMessage, &err):
+			rft.Clear(fd)
+		}
+		return true
+	})
+	return err
+}
+
+// pruneMessage prunes the message which is a singular field value, a list element or a map value.
+func (f *compiledField) pruneMessage(m protoreflect.Message, o *options) error {
+	if o.resolvesAny(m.Descriptor()) {
+		return o.applyToAny(m, f.mask, NestedMask.prune)
+	}
+	return f.message.prune(m, o)
+}
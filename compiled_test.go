@@ -0,0 +1,108 @@
+package fmutils
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestCompiledMask_Filter(t *testing.T) {
+	for _, msg := range append(copyTestMessages, newLeaderboard()) {
+		for _, paths := range append(copyTestPaths, []string{"scores.+42.value", "labels.*", "bool_scores.true"}) {
+			mask := NestedMaskFromPaths(paths)
+			want := proto.Clone(msg)
+			mask.Filter(want, WithAnyResolver(protoregistry.GlobalTypes))
+
+			got := proto.Clone(msg)
+			mask.Compile(msg.ProtoReflect().Descriptor()).Filter(got, WithAnyResolver(protoregistry.GlobalTypes))
+			if !proto.Equal(got, want) {
+				t.Errorf("Filter(%v, %v) = %v, want %v", msg, paths, got, want)
+			}
+		}
+	}
+}
+
+func TestCompiledMask_Prune(t *testing.T) {
+	for _, msg := range append(copyTestMessages, newLeaderboard()) {
+		for _, paths := range append(copyTestPaths, []string{"scores.+42.value", "labels.*", "bool_scores.true"}) {
+			mask := NestedMaskFromPaths(paths)
+			want := proto.Clone(msg)
+			mask.Prune(want, WithAnyResolver(protoregistry.GlobalTypes))
+
+			got := proto.Clone(msg)
+			mask.Compile(msg.ProtoReflect().Descriptor()).Prune(got, WithAnyResolver(protoregistry.GlobalTypes))
+			if !proto.Equal(got, want) {
+				t.Errorf("Prune(%v, %v) = %v, want %v", msg, paths, got, want)
+			}
+		}
+	}
+}
+
+func TestCompiledMask_reuse(t *testing.T) {
+	mask := NestedMaskFromPaths([]string{"name"}).Compile((&testproto.User{}).ProtoReflect().Descriptor())
+	users := []*testproto.User{{UserId: 1, Name: "name 1"}, {UserId: 2, Name: "name 2"}}
+	for _, user := range users {
+		mask.Filter(user)
+	}
+	for i, user := range users {
+		if user.UserId != 0 || user.Name == "" {
+			t.Errorf("users[%d] = %v, want the name only", i, user)
+		}
+	}
+}
+
+func TestCompiledMask_mismatchingTypes(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Filter() did not panic")
+		}
+	}()
+	NestedMaskFromPaths([]string{"name"}).Compile((&testproto.User{}).ProtoReflect().Descriptor()).Filter(&testproto.Photo{})
+}
+
+func BenchmarkNestedMask_Filter(b *testing.B) {
+	mask := NestedMaskFromPaths([]string{"user.name", "photo.dimensions", "gallery.path", "attributes.a1"})
+	benchmarkFilter(b, mask.Filter)
+}
+
+func BenchmarkCompiledMask_Filter(b *testing.B) {
+	mask := NestedMaskFromPaths([]string{"user.name", "photo.dimensions", "gallery.path", "attributes.a1"}).
+		Compile((&testproto.Profile{}).ProtoReflect().Descriptor())
+	benchmarkFilter(b, mask.Filter)
+}
+
+// benchmarkFilter times only the filter calls, the profiles are built in batches with the timer stopped.
+func benchmarkFilter(b *testing.B, filter func(proto.Message, ...Option)) {
+	profiles := make([]*testproto.Profile, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%len(profiles) == 0 {
+			b.StopTimer()
+			for j := range profiles {
+				profiles[j] = newBenchmarkProfile()
+			}
+			b.StartTimer()
+		}
+		filter(profiles[i%len(profiles)])
+	}
+}
+
+func newBenchmarkProfile() *testproto.Profile {
+	return &testproto.Profile{
+		User:            &testproto.User{UserId: 1, Name: "name"},
+		Photo:           &testproto.Photo{PhotoId: 1, Path: "path", Dimensions: &testproto.Dimensions{Width: 1, Height: 2}},
+		LoginTimestamps: []int64{1, 2, 3},
+		Gallery: []*testproto.Photo{
+			{PhotoId: 2, Path: "path 2"},
+			{PhotoId: 3, Path: "path 3"},
+		},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "1"}},
+			"a2": {Tags: map[string]string{"t2": "2"}},
+		},
+	}
+}
@@ -0,0 +1,219 @@
+package fmutils
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// FilterCopy returns a new message with only the msg fields that are listed in the paths, the msg is left untouched.
+//
+// This is a handy wrapper for NestedMask.FilterCopy method.
+// If the same paths are used to process multiple proto messages use NestedMask.FilterCopy method directly.
+func FilterCopy(msg proto.Message, paths []string, opts ...Option) proto.Message {
+	return NestedMaskFromPaths(paths).FilterCopy(msg, opts...)
+}
+
+// PruneCopy returns a new message with all the msg fields except the ones listed in the paths, the msg is left
+// untouched.
+//
+// This is a handy wrapper for NestedMask.PruneCopy method.
+// If the same paths are used to process multiple proto messages use NestedMask.PruneCopy method directly.
+func PruneCopy(msg proto.Message, paths []string, opts ...Option) proto.Message {
+	return NestedMaskFromPaths(paths).PruneCopy(msg, opts...)
+}
+
+// FilterCopy returns a new message with only the msg fields that are listed in the mask, the msg is left untouched.
+//
+// The result is the same as of NestedMask.Filter applied to a proto.Clone of the msg, except that the fields that
+// are not listed in the mask are never copied.
+func (mask NestedMask) FilterCopy(msg proto.Message, opts ...Option) proto.Message {
+	src := msg.ProtoReflect()
+	dst := src.New()
+	o := newOptions(opts)
+	_ = o.protoNames(mask, src.Descriptor()).filterCopy(dst, src, o)
+	return dst.Interface()
+}
+
+// PruneCopy returns a new message with all the msg fields except the ones listed in the mask, the msg is left
+// untouched.
+//
+// The result is the same as of NestedMask.Prune applied to a proto.Clone of the msg, except that the fields that
+// are listed in the mask are never copied.
+func (mask NestedMask) PruneCopy(msg proto.Message, opts ...Option) proto.Message {
+	src := msg.ProtoReflect()
+	dst := src.New()
+	o := newOptions(opts)
+	_ = o.protoNames(mask, src.Descriptor()).pruneCopy(dst, src, o)
+	return dst.Interface()
+}
+
+func (mask NestedMask) filterCopy(dst, src protoreflect.Message, o *options) error {
+	if len(mask) == 0 {
+		proto.Merge(dst.Interface(), src.Interface())
+		return nil
+	}
+
+	var err error
+	if o.keepUnknown {
+		copyUnknown(dst, src)
+	}
+	src.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		m, ok := mask.child(string(fd.Name()))
+		if !ok {
+			return true
+		}
+		if len(m) == 0 {
+			dst.Set(fd, copyValue(dst.NewField(fd), v))
+			return true
+		}
+
+		if fd.IsMap() {
+			m := m.withMapKeys(fd.MapKey())
+			var xmap protoreflect.Map
+			v.Map().Range(func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
+				mi, ok := m.child(mk.String())
+				if !ok {
+					return true
+				}
+				if xmap == nil {
+					xmap = dst.Mutable(fd).Map()
+				}
+				nv := xmap.NewValue()
+				if i, ok := mv.Interface().(protoreflect.Message); ok && len(mi) > 0 {
+					err = firstError(err, mi.filterCopyMessage(nv.Message(), i, o))
+					if o.leftEmpty(nv.Message(), i) {
+						return true
+					}
+				} else {
+					nv = copyValue(nv, mv)
+				}
+				xmap.Set(mk, nv)
+				return true
+			})
+		} else if fd.IsList() {
+			src, list, l := v.List(), dst.Mutable(fd).List(), m.listMask(fd)
+			for i := 0; i < src.Len(); i++ {
+				mi, ok := l.element(i, src.Len(), src.Get(i))
+				if !ok {
+					continue
+				}
+				nv := list.NewElement()
+				if e, ok := src.Get(i).Interface().(protoreflect.Message); ok && len(mi) > 0 {
+					err = firstError(err, mi.filterCopyMessage(nv.Message(), e, o))
+					if o.leftEmpty(nv.Message(), e) {
+						continue
+					}
+				} else {
+					nv = copyValue(nv, src.Get(i))
+				}
+				list.Append(nv)
+			}
+		} else if fd.Kind() == protoreflect.MessageKind {
+			err = firstError(err, m.filterCopyMessage(dst.Mutable(fd).Message(), v.Message(), o))
+			if o.leftEmpty(dst.Get(fd).Message(), v.Message()) {
+				dst.Clear(fd)
+			}
+		} else {
+			dst.Set(fd, copyValue(dst.NewField(fd), v))
+		}
+		return true
+	})
+	return err
+}
+
+// filterCopyMessage copies the message which is a singular field value, a list element or a map value.
+func (mask NestedMask) filterCopyMessage(dst, src protoreflect.Message, o *options) error {
+	if o.resolvesAny(src.Descriptor()) {
+		return o.copyAny(dst, src, mask, NestedMask.filterCopy)
+	}
+	return mask.filterCopy(dst, src, o)
+}
+
+func (mask NestedMask) pruneCopy(dst, src protoreflect.Message, o *options) error {
+	if len(mask) == 0 {
+		proto.Merge(dst.Interface(), src.Interface())
+		return nil
+	}
+
+	var err error
+	copyUnknown(dst, src)
+	src.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		m, ok := mask.child(string(fd.Name()))
+		if !ok {
+			dst.Set(fd, copyValue(dst.NewField(fd), v))
+			return true
+		}
+		if len(m) == 0 {
+			return true
+		}
+
+		if fd.IsMap() {
+			m := m.withMapKeys(fd.MapKey())
+			var xmap protoreflect.Map
+			v.Map().Range(func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
+				mi, ok := m.child(mk.String())
+				i, isMessage := mv.Interface().(protoreflect.Message)
+				if ok && (!isMessage || len(mi) == 0) {
+					return true
+				}
+				if xmap == nil {
+					xmap = dst.Mutable(fd).Map()
+				}
+				nv := xmap.NewValue()
+				if ok {
+					err = firstError(err, mi.pruneCopyMessage(nv.Message(), i, o))
+					if o.leftEmpty(nv.Message(), i) {
+						return true
+					}
+				} else {
+					nv = copyValue(nv, mv)
+				}
+				xmap.Set(mk, nv)
+				return true
+			})
+		} else if fd.IsList() {
+			src, list, l := v.List(), dst.Mutable(fd).List(), m.listMask(fd)
+			for i := 0; i < src.Len(); i++ {
+				mi, ok := l.element(i, src.Len(), src.Get(i))
+				e, isMessage := src.Get(i).Interface().(protoreflect.Message)
+				if ok && (!isMessage || len(mi) == 0) {
+					continue
+				}
+				nv := list.NewElement()
+				if ok {
+					err = firstError(err, mi.pruneCopyMessage(nv.Message(), e, o))
+					if o.leftEmpty(nv.Message(), e) {
+						continue
+					}
+				} else {
+					nv = copyValue(nv, src.Get(i))
+				}
+				list.Append(nv)
+			}
+		} else if fd.Kind() == protoreflect.MessageKind {
+			err = firstError(err, m.pruneCopyMessage(dst.Mutable(fd).Message(), v.Message(), o))
+			if o.leftEmpty(dst.Get(fd).Message(), v.Message()) {
+				dst.Clear(fd)
+			}
+		} else {
+			dst.Set(fd, copyValue(dst.NewField(fd), v))
+		}
+		return true
+	})
+	return err
+}
+
+// pruneCopyMessage copies the message which is a singular field value, a list element or a map value.
+func (mask NestedMask) pruneCopyMessage(dst, src protoreflect.Message, o *options) error {
+	if o.resolvesAny(src.Descriptor()) {
+		return o.copyAny(dst, src, mask, NestedMask.pruneCopy)
+	}
+	return mask.pruneCopy(dst, src, o)
+}
+
+// copyUnknown copies the unknown fields of src into dst.
+func copyUnknown(dst, src protoreflect.Message) {
+	if unknown := src.GetUnknown(); len(unknown) != 0 {
+		dst.SetUnknown(append(protoreflect.RawFields(nil), unknown...))
+	}
+}
@@ -0,0 +1,127 @@
+package fmutils
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+var copyTestMessages = []proto.Message{
+	&testproto.Profile{
+		User: &testproto.User{
+			UserId: 1,
+			Name:   "user name",
+		},
+		Photo: &testproto.Photo{
+			PhotoId: 2,
+			Path:    "photo path",
+			Dimensions: &testproto.Dimensions{
+				Width:  100,
+				Height: 120,
+			},
+		},
+		LoginTimestamps: []int64{1, 2},
+		Gallery: []*testproto.Photo{
+			{PhotoId: 3, Path: "path 3", Dimensions: &testproto.Dimensions{Width: 10}},
+			{PhotoId: 4, Path: "path 4"},
+		},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+			"a2": {Tags: map[string]string{"t3": "3"}},
+			"a3": {},
+		},
+	},
+	&testproto.Event{
+		EventId: 1,
+		Changed: &testproto.Event_Details{
+			Details: createAny(&testproto.User{UserId: 1, Name: "user name"}),
+		},
+	},
+	&testproto.Event{
+		EventId: 2,
+		Changed: &testproto.Event_Status{Status: testproto.Status_OK},
+	},
+}
+
+var copyTestPaths = [][]string{
+	{},
+	{"user"},
+	{"user.name", "photo.dimensions.width", "event_id"},
+	{"login_timestamps", "gallery.path", "gallery.dimensions"},
+	{"attributes.a1.tags.t1", "attributes.a2", "attributes.a4"},
+	{"attributes.a1.tags", "attributes.a3.tags"},
+	{"details", "status"},
+	{"details.type_url", "user"},
+	{"user.*", "attributes.*.tags.t1", "attributes.a2"},
+}
+
+func TestFilterCopy(t *testing.T) {
+	for _, msg := range copyTestMessages {
+		for _, paths := range copyTestPaths {
+			orig := proto.Clone(msg)
+			want := proto.Clone(msg)
+			Filter(want, paths)
+
+			got := FilterCopy(msg, paths)
+			if !proto.Equal(got, want) {
+				t.Errorf("FilterCopy(%v, %v) = %v, want %v", msg, paths, got, want)
+			}
+			if !proto.Equal(msg, orig) {
+				t.Errorf("FilterCopy(%v, %v) modified the msg", msg, paths)
+			}
+		}
+	}
+}
+
+func TestPruneCopy(t *testing.T) {
+	for _, msg := range copyTestMessages {
+		for _, paths := range copyTestPaths {
+			orig := proto.Clone(msg)
+			want := proto.Clone(msg)
+			Prune(want, paths)
+
+			got := PruneCopy(msg, paths)
+			if !proto.Equal(got, want) {
+				t.Errorf("PruneCopy(%v, %v) = %v, want %v", msg, paths, got, want)
+			}
+			if !proto.Equal(msg, orig) {
+				t.Errorf("PruneCopy(%v, %v) modified the msg", msg, paths)
+			}
+		}
+	}
+}
+
+func TestFilterCopy_doesNotShareValues(t *testing.T) {
+	msg := &testproto.Profile{
+		Photo:   &testproto.Photo{Path: "path"},
+		Gallery: []*testproto.Photo{{Path: "path"}},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "1"}},
+		},
+	}
+	got := FilterCopy(msg, []string{"photo", "gallery.path", "attributes.a1"}).(*testproto.Profile)
+	got.Photo.Path = "new path"
+	got.Gallery[0].Path = "new path"
+	got.Attributes["a1"].Tags["t1"] = "new 1"
+
+	want := &testproto.Profile{
+		Photo:   &testproto.Photo{Path: "path"},
+		Gallery: []*testproto.Photo{{Path: "path"}},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "1"}},
+		},
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
+
+func BenchmarkNestedMask_FilterCopy(b *testing.B) {
+	mask := NestedMaskFromPaths([]string{"user.name", "photo.dimensions", "attributes.a1"})
+	msg := copyTestMessages[0]
+	for i := 0; i < b.N; i++ {
+		mask.FilterCopy(msg)
+	}
+}
@@ -0,0 +1,132 @@
+package fmutils
+
+import (
+	"bytes"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Diff returns the minimal mask of the fields that differ between a and b.
+//
+// Singular message fields that are populated in both a and b are compared field by field, maps are compared entry by
+// entry. All the other fields, including the repeated ones, are compared as a whole. A map that has a different entry
+// with a key that has a special meaning in a mask, e.g. "*", is listed as a whole.
+// The result is consistent with NestedMask.Overwrite: overwriting a with b using the returned mask makes a equal to b.
+// The a and b messages must be of the same type otherwise the function panics.
+func Diff(a, b proto.Message) NestedMask {
+	ra, rb := a.ProtoReflect(), b.ProtoReflect()
+	if ra.Descriptor().FullName() != rb.Descriptor().FullName() {
+		panic(fmt.Sprintf("fmutils: can not diff %s and %s", ra.Descriptor().FullName(), rb.Descriptor().FullName()))
+	}
+	return diffMessage(ra, rb)
+}
+
+func diffMessage(a, b protoreflect.Message) NestedMask {
+	mask := make(NestedMask)
+	fields := a.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		hasA, hasB := a.Has(fd), b.Has(fd)
+		if !hasA && !hasB {
+			continue
+		}
+
+		switch {
+		case fd.IsMap():
+			if m := diffMap(fd, a.Get(fd).Map(), b.Get(fd).Map()); len(m) != 0 {
+				if m.hasReservedKeys() {
+					// The keys would be read as the wildcard or the exclusions, so the map is listed as a whole.
+					m = NestedMask{}
+				}
+				mask[string(fd.Name())] = m
+			}
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil && hasA && hasB:
+			if m := diffMessage(a.Get(fd).Message(), b.Get(fd).Message()); len(m) != 0 {
+				mask[string(fd.Name())] = m
+			}
+		default:
+			if hasA != hasB || !equalValue(fd, a.Get(fd), b.Get(fd)) {
+				mask[string(fd.Name())] = NestedMask{}
+			}
+		}
+	}
+	return mask
+}
+
+func diffMap(fd protoreflect.FieldDescriptor, a, b protoreflect.Map) NestedMask {
+	mask := make(NestedMask)
+	vd := fd.MapValue()
+	a.Range(func(mk protoreflect.MapKey, va protoreflect.Value) bool {
+		if !b.Has(mk) {
+			mask[mk.String()] = NestedMask{}
+			return true
+		}
+		vb := b.Get(mk)
+		if vd.Message() != nil {
+			if m := diffMessage(va.Message(), vb.Message()); len(m) != 0 {
+				mask[mk.String()] = m
+			}
+		} else if !equalSingular(vd, va, vb) {
+			mask[mk.String()] = NestedMask{}
+		}
+		return true
+	})
+	b.Range(func(mk protoreflect.MapKey, _ protoreflect.Value) bool {
+		if !a.Has(mk) {
+			mask[mk.String()] = NestedMask{}
+		}
+		return true
+	})
+	return mask
+}
+
+// hasReservedKeys reports whether the mask of a map has a key that has a special meaning in a mask.
+func (mask NestedMask) hasReservedKeys() bool {
+	_, hasWildcard := mask[wildcard]
+	_, hasExclusion := mask[exclusion]
+	return hasWildcard || hasExclusion
+}
+
+// equalValue reports whether the values of the field are equal.
+func equalValue(fd protoreflect.FieldDescriptor, a, b protoreflect.Value) bool {
+	switch {
+	case fd.IsList():
+		la, lb := a.List(), b.List()
+		if la.Len() != lb.Len() {
+			return false
+		}
+		for i := 0; i < la.Len(); i++ {
+			if !equalSingular(fd, la.Get(i), lb.Get(i)) {
+				return false
+			}
+		}
+		return true
+	case fd.IsMap():
+		ma, mb := a.Map(), b.Map()
+		if ma.Len() != mb.Len() {
+			return false
+		}
+		equal := true
+		ma.Range(func(mk protoreflect.MapKey, v protoreflect.Value) bool {
+			equal = mb.Has(mk) && equalSingular(fd.MapValue(), v, mb.Get(mk))
+			return equal
+		})
+		return equal
+	default:
+		return equalSingular(fd, a, b)
+	}
+}
+
+// equalSingular reports whether the values of a singular field, a list element or a map value are equal.
+func equalSingular(fd protoreflect.FieldDescriptor, a, b protoreflect.Value) bool {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return proto.Equal(a.Message().Interface(), b.Message().Interface())
+	case protoreflect.BytesKind:
+		return bytes.Equal(a.Bytes(), b.Bytes())
+	default:
+		return a.Interface() == b.Interface()
+	}
+}
@@ -0,0 +1,153 @@
+package fmutils
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    proto.Message
+		b    proto.Message
+		want NestedMask
+	}{
+		{
+			name: "equal messages",
+			a: &testproto.Profile{
+				User:            &testproto.User{UserId: 1, Name: "name"},
+				LoginTimestamps: []int64{1, 2},
+			},
+			b: &testproto.Profile{
+				User:            &testproto.User{UserId: 1, Name: "name"},
+				LoginTimestamps: []int64{1, 2},
+			},
+			want: NestedMask{},
+		},
+		{
+			name: "scalar fields",
+			a: &testproto.Profile{
+				User:  &testproto.User{UserId: 1, Name: "name"},
+				Photo: &testproto.Photo{PhotoId: 1, Dimensions: &testproto.Dimensions{Width: 100, Height: 120}},
+			},
+			b: &testproto.Profile{
+				User:  &testproto.User{UserId: 1, Name: "new name"},
+				Photo: &testproto.Photo{PhotoId: 1, Dimensions: &testproto.Dimensions{Width: 50, Height: 120}},
+			},
+			want: NestedMask{
+				"user":  NestedMask{"name": NestedMask{}},
+				"photo": NestedMask{"dimensions": NestedMask{"width": NestedMask{}}},
+			},
+		},
+		{
+			name: "message fields presence",
+			a: &testproto.Profile{
+				User: &testproto.User{},
+			},
+			b: &testproto.Profile{
+				Photo: &testproto.Photo{},
+			},
+			want: NestedMask{"user": NestedMask{}, "photo": NestedMask{}},
+		},
+		{
+			name: "repeated fields are compared as a whole",
+			a: &testproto.Profile{
+				LoginTimestamps: []int64{1, 2},
+				Gallery:         []*testproto.Photo{{PhotoId: 1}, {PhotoId: 2}},
+			},
+			b: &testproto.Profile{
+				LoginTimestamps: []int64{1, 2, 3},
+				Gallery:         []*testproto.Photo{{PhotoId: 1}, {PhotoId: 2, Path: "path"}},
+			},
+			want: NestedMask{"login_timestamps": NestedMask{}, "gallery": NestedMask{}},
+		},
+		{
+			name: "map entries",
+			a: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+					"a2": {Tags: map[string]string{"t1": "1"}},
+					"a3": {},
+				},
+			},
+			b: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1", "t2": "new 2", "t3": "3"}},
+					"a2": {Tags: map[string]string{"t1": "1"}},
+					"a4": {},
+				},
+			},
+			want: NestedMask{
+				"attributes": NestedMask{
+					"a1": NestedMask{"tags": NestedMask{"t2": NestedMask{}, "t3": NestedMask{}}},
+					"a3": NestedMask{},
+					"a4": NestedMask{},
+				},
+			},
+		},
+		{
+			name: "maps with non-string keys",
+			a: &testproto.Leaderboard{
+				Scores:     map[int64]*testproto.Score{-1: {Value: 1}, 2: {Value: 2}},
+				BoolScores: map[bool]*testproto.Score{true: {Value: 1}},
+				Labels:     map[int64]string{1: "one"},
+			},
+			b: &testproto.Leaderboard{
+				Scores:     map[int64]*testproto.Score{-1: {Value: 10}, 2: {Value: 2}},
+				BoolScores: map[bool]*testproto.Score{true: {Value: 1}, false: {}},
+				Labels:     map[int64]string{1: "uno"},
+			},
+			want: NestedMask{
+				"scores":      NestedMask{"-1": NestedMask{"value": NestedMask{}}},
+				"bool_scores": NestedMask{"false": NestedMask{}},
+				"labels":      NestedMask{"1": NestedMask{}},
+			},
+		},
+		{
+			name: "map keys that look like the wildcard or the exclusions",
+			a: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{"!": {}},
+			},
+			b:    &testproto.Profile{},
+			want: NestedMask{"attributes": NestedMask{"!": NestedMask{}}},
+		},
+		{
+			name: "maps with the reserved keys are listed as a whole",
+			a: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{"*": {}, "a1": {}},
+			},
+			b: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{"a1": {Tags: map[string]string{"t1": "1"}}, "\xff": {}},
+			},
+			want: NestedMask{"attributes": NestedMask{}},
+		},
+		{
+			name: "oneof fields",
+			a: &testproto.Event{
+				EventId: 1,
+				Changed: &testproto.Event_User{User: &testproto.User{UserId: 1}},
+			},
+			b: &testproto.Event{
+				EventId: 1,
+				Changed: &testproto.Event_Status{Status: testproto.Status_OK},
+			},
+			want: NestedMask{"user": NestedMask{}, "status": NestedMask{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %v, want %v", got, tt.want)
+			}
+			got.Overwrite(tt.a, tt.b)
+			if !proto.Equal(tt.a, tt.b) {
+				t.Errorf("Overwrite() with the diff = %v, want %v", tt.a, tt.b)
+			}
+		})
+	}
+}
@@ -85,3 +85,74 @@ func ExampleFilter_reuse_mask() {
 	fmt.Println(users)
 	// Output: [name:"name 1" name:"name 2"]
 }
+
+// ExampleNestedMask_Compile illustrates how a mask can be compiled once for a message type and applied to many
+// messages of that type without resolving the field names every time.
+func ExampleNestedMask_Compile() {
+	users := []*testproto.User{
+		{
+			UserId: 1,
+			Name:   "name 1",
+		},
+		{
+			UserId: 2,
+			Name:   "name 2",
+		},
+	}
+	mask := fmutils.NestedMaskFromPaths([]string{"name"}).Compile((&testproto.User{}).ProtoReflect().Descriptor())
+	for _, user := range users {
+		mask.Filter(user)
+	}
+	fmt.Println(users)
+	// Output: [name:"name 1" name:"name 2"]
+}
+
+// ExampleOverwrite_update_request illustrates an API endpoint that updates an existing entity following the
+// https://google.aip.dev/134 semantics: the fields listed in the field mask are replaced rather than merged.
+func ExampleOverwrite_update_request() {
+	// Assuming the profile entity is loaded from a database.
+	profile := &testproto.Profile{
+		User: &testproto.User{
+			UserId: 64,
+			Name:   "user name",
+		},
+		Photo: &testproto.Photo{
+			PhotoId: 2,
+			Path:    "photo path",
+			Dimensions: &testproto.Dimensions{
+				Width:  100,
+				Height: 120,
+			},
+		},
+		LoginTimestamps: []int64{1, 2, 3},
+	}
+	// An API request from an API user.
+	updateProfileRequest := &testproto.UpdateProfileRequest{
+		Profile: &testproto.Profile{
+			User: &testproto.User{
+				UserId: 65, // not listed in the field mask, so won't be updated.
+				Name:   "new user name",
+			},
+			Photo: &testproto.Photo{
+				PhotoId: 3,  // not listed in the field mask, so won't be updated.
+				Path:    "", // listed in the field mask, so will be cleared.
+				Dimensions: &testproto.Dimensions{
+					Width: 50,
+				},
+			},
+			LoginTimestamps: []int64{4, 5}},
+		Fieldmask: &field_mask.FieldMask{
+			Paths: []string{"user.name", "photo.path", "photo.dimensions.width", "login_timestamps"}},
+	}
+	// Validate the field mask before using it.
+	mask := fmutils.NestedMaskFromPaths(updateProfileRequest.Fieldmask.GetPaths())
+	if err := mask.Validate(profile.ProtoReflect().Descriptor()); err != nil {
+		// Return an error.
+		panic(err)
+	}
+	// Overwrite the masked fields of the profile entity with the values from the request.
+	mask.Overwrite(profile, updateProfileRequest.GetProfile())
+	// The profile can now be saved in a database.
+	fmt.Println(reSpaces.ReplaceAllString(profile.String(), " "))
+	// Output: user:{user_id:64 name:"new user name"} photo:{photo_id:2 dimensions:{width:50 height:120}} login_timestamps:4 login_timestamps:5
+}
@@ -0,0 +1,346 @@
+package fmutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func newExclusionProfile() *testproto.Profile {
+	return &testproto.Profile{
+		User:            &testproto.User{UserId: 1, Name: "name"},
+		Photo:           &testproto.Photo{PhotoId: 1, Path: "path", Dimensions: &testproto.Dimensions{Width: 1, Height: 2}},
+		LoginTimestamps: []int64{1, 2},
+		Gallery:         newGalleryProfile().Gallery,
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+			"a2": {Tags: map[string]string{"t1": "1"}},
+		},
+	}
+}
+
+func TestExclusionPaths(t *testing.T) {
+	tests := []struct {
+		name       string
+		paths      []string
+		wantFilter proto.Message
+		wantPrune  proto.Message
+	}{
+		{
+			name:  "subfield excluded from a field",
+			paths: []string{"user", "photo.dimensions", "-user.user_id", "-photo.dimensions.width"},
+			wantFilter: &testproto.Profile{
+				User:  &testproto.User{Name: "name"},
+				Photo: &testproto.Photo{Dimensions: &testproto.Dimensions{Height: 2}},
+			},
+			wantPrune: &testproto.Profile{
+				User:            &testproto.User{UserId: 1},
+				Photo:           &testproto.Photo{PhotoId: 1, Path: "path", Dimensions: &testproto.Dimensions{Width: 1}},
+				LoginTimestamps: []int64{1, 2},
+				Gallery:         newGalleryProfile().Gallery,
+				Attributes:      newExclusionProfile().Attributes,
+			},
+		},
+		{
+			name:  "everything except the excluded paths",
+			paths: []string{"*", "-user.user_id", "-photo", "-gallery", "-attributes.a1.tags.t1"},
+			wantFilter: &testproto.Profile{
+				User:            &testproto.User{Name: "name"},
+				LoginTimestamps: []int64{1, 2},
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t2": "2"}},
+					"a2": {Tags: map[string]string{"t1": "1"}},
+				},
+			},
+			wantPrune: &testproto.Profile{
+				User:       &testproto.User{UserId: 1},
+				Photo:      newExclusionProfile().Photo,
+				Gallery:    newGalleryProfile().Gallery,
+				Attributes: map[string]*testproto.Attribute{"a1": {Tags: map[string]string{"t1": "1"}}},
+			},
+		},
+		{
+			name:  "map entry excluded",
+			paths: []string{"attributes", "-attributes.a1"},
+			wantFilter: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{"a2": {Tags: map[string]string{"t1": "1"}}},
+			},
+			wantPrune: &testproto.Profile{
+				User:            newExclusionProfile().User,
+				Photo:           newExclusionProfile().Photo,
+				LoginTimestamps: []int64{1, 2},
+				Gallery:         newGalleryProfile().Gallery,
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+				},
+			},
+		},
+		{
+			name:  "subfield excluded from the list elements",
+			paths: []string{"gallery", "-gallery.dimensions"},
+			wantFilter: &testproto.Profile{Gallery: []*testproto.Photo{
+				{PhotoId: 1, Path: "path 1"}, {PhotoId: 2, Path: "path 2"}, {PhotoId: 3, Path: "path 3"},
+			}},
+			wantPrune: &testproto.Profile{
+				User:            newExclusionProfile().User,
+				Photo:           newExclusionProfile().Photo,
+				LoginTimestamps: []int64{1, 2},
+				Gallery: []*testproto.Photo{
+					{Dimensions: &testproto.Dimensions{Width: 1, Height: 1}},
+					{Dimensions: &testproto.Dimensions{Width: 2, Height: 2}},
+					{Dimensions: &testproto.Dimensions{Width: 3, Height: 3}},
+				},
+				Attributes: newExclusionProfile().Attributes,
+			},
+		},
+		{
+			name: "list elements excluded by the index and the selector",
+			paths: []string{
+				"gallery.path", "login_timestamps", "-gallery.0", "-gallery[photo_id=3].path", "-login_timestamps.-1",
+			},
+			wantFilter: &testproto.Profile{LoginTimestamps: []int64{1}, Gallery: []*testproto.Photo{{Path: "path 2"}, {}}},
+			wantPrune: &testproto.Profile{
+				User:            newExclusionProfile().User,
+				Photo:           newExclusionProfile().Photo,
+				LoginTimestamps: []int64{2},
+				Gallery: []*testproto.Photo{
+					{PhotoId: 1, Path: "path 1", Dimensions: &testproto.Dimensions{Width: 1, Height: 1}},
+					{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 2, Height: 2}},
+					{PhotoId: 3, Path: "path 3", Dimensions: &testproto.Dimensions{Width: 3, Height: 3}},
+				},
+				Attributes: newExclusionProfile().Attributes,
+			},
+		},
+		{
+			name:       "only the exclusions list nothing",
+			paths:      []string{"-user.user_id", "-photo"},
+			wantFilter: &testproto.Profile{},
+			wantPrune:  newExclusionProfile(),
+		},
+		{
+			name:       "excluded field is not listed",
+			paths:      []string{"user.name", "-user"},
+			wantFilter: &testproto.Profile{},
+			wantPrune:  newExclusionProfile(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, err := ParseNestedMask(tt.paths)
+			if err != nil {
+				t.Fatalf("ParseNestedMask() error = %v", err)
+			}
+			md := (&testproto.Profile{}).ProtoReflect().Descriptor()
+
+			got := newExclusionProfile()
+			mask.Filter(got)
+			if !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("Filter() = %v, want %v", got, tt.wantFilter)
+			}
+			if got := mask.FilterCopy(newExclusionProfile()); !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("FilterCopy() = %v, want %v", got, tt.wantFilter)
+			}
+			got = newExclusionProfile()
+			mask.Compile(md).Filter(got)
+			if !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("CompiledMask.Filter() = %v, want %v", got, tt.wantFilter)
+			}
+
+			got = newExclusionProfile()
+			mask.Prune(got)
+			if !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("Prune() = %v, want %v", got, tt.wantPrune)
+			}
+			if got := mask.PruneCopy(newExclusionProfile()); !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("PruneCopy() = %v, want %v", got, tt.wantPrune)
+			}
+			got = newExclusionProfile()
+			mask.Compile(md).Prune(got)
+			if !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("CompiledMask.Prune() = %v, want %v", got, tt.wantPrune)
+			}
+
+			if err := mask.Validate(md); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestExclusionPaths_parse(t *testing.T) {
+	mask, err := ParseNestedMask([]string{"profile", "-profile.user.user_id", "-labels.`-1`", "-gallery[path=`[a]`]"})
+	if err != nil {
+		t.Fatalf("ParseNestedMask() error = %v", err)
+	}
+	want := NestedMask{
+		"profile": NestedMask{},
+		exclusion: NestedMask{
+			"profile": NestedMask{"user": NestedMask{"user_id": NestedMask{}}},
+			"labels":  NestedMask{"-1": NestedMask{}},
+			"gallery": NestedMask{"[path=[a]]": NestedMask{}},
+		},
+	}
+	if !reflect.DeepEqual(mask, want) {
+		t.Errorf("ParseNestedMask() = %v, want %v", mask, want)
+	}
+	wantPaths := []string{"-gallery[path=`[a]`]", "-labels.-1", "-profile.user.user_id", "profile"}
+	if got := mask.Paths(); !reflect.DeepEqual(got, wantPaths) {
+		t.Errorf("Paths() = %v, want %v", got, wantPaths)
+	}
+	if got := NestedMaskFromPaths(wantPaths); !reflect.DeepEqual(got, want) {
+		t.Errorf("NestedMaskFromPaths(Paths()) = %v, want %v", got, want)
+	}
+	if got := NestedMaskFromPaths([]string{"-", "-."}); len(got) != 0 {
+		t.Errorf("NestedMaskFromPaths() = %v, want an empty mask", got)
+	}
+
+	if got, want := NestedMaskFromPaths([]string{"a.!", "-a.`!`.b", "c.\xff"}), (NestedMask{
+		"a":       NestedMask{"!": NestedMask{}},
+		"c":       NestedMask{"\uFFFD": NestedMask{}},
+		exclusion: NestedMask{"a": NestedMask{"!": NestedMask{"b": NestedMask{}}}},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("NestedMaskFromPaths() = %v, want %v", got, want)
+	}
+
+	for path, wantOffset := range map[string]int{"-": 1, "-a..b": 3, "a.\xff.b": 2, "-`\xff`": 1} {
+		_, err := ParseNestedMask([]string{path})
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("ParseNestedMask(%q) error = %v, want a *SyntaxError", path, err)
+			continue
+		}
+		if syntaxErr.Path != path || syntaxErr.Offset != wantOffset {
+			t.Errorf("ParseNestedMask(%q) error = %v, want offset %d", path, err, wantOffset)
+		}
+	}
+}
+
+func TestExclusionPaths_algebra(t *testing.T) {
+	tests := []struct {
+		name          string
+		a, b          []string
+		wantUnion     []string
+		wantIntersect []string
+		wantSubtract  []string
+		wantWithout   []string
+	}{
+		{
+			name:          "exclusions of the same field",
+			a:             []string{"user", "-user.user_id"},
+			b:             []string{"user", "photo", "-user.name"},
+			wantUnion:     []string{"photo", "user"},
+			wantIntersect: []string{"-user.name", "-user.user_id", "user"},
+			wantSubtract:  nil,
+			wantWithout:   nil,
+		},
+		{
+			name:          "exclusion kept unless the other mask covers it",
+			a:             []string{"*", "-user.user_id", "-photo"},
+			b:             []string{"photo.path"},
+			wantUnion:     []string{"*", "-user.user_id"},
+			wantIntersect: nil,
+			wantSubtract:  nil,
+			wantWithout:   []string{"*", "-photo", "-user.user_id"},
+		},
+		{
+			name:          "mask minus everything except a field",
+			a:             []string{"user", "photo"},
+			b:             []string{"*", "-photo.path"},
+			wantUnion:     []string{"*"},
+			wantIntersect: []string{"-photo.path", "photo", "user"},
+			wantSubtract:  nil,
+			wantWithout:   nil,
+		},
+		{
+			name:          "exclusions outside the mask are dropped",
+			a:             []string{"user", "-photo.path"},
+			b:             []string{"user.name"},
+			wantUnion:     []string{"user"},
+			wantIntersect: []string{"user.name"},
+			wantSubtract:  nil,
+			wantWithout:   []string{"-user.name", "user"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := NestedMaskFromPaths(tt.a), NestedMaskFromPaths(tt.b)
+			if got := a.Union(b).Paths(); !reflect.DeepEqual(got, tt.wantUnion) {
+				t.Errorf("Union() = %v, want %v", got, tt.wantUnion)
+			}
+			if got := a.Intersect(b).Paths(); !reflect.DeepEqual(got, tt.wantIntersect) {
+				t.Errorf("Intersect() = %v, want %v", got, tt.wantIntersect)
+			}
+			if got := a.Subtract(b).Paths(); !reflect.DeepEqual(got, tt.wantSubtract) {
+				t.Errorf("Subtract() = %v, want %v", got, tt.wantSubtract)
+			}
+			if got := a.Without(b).Paths(); !reflect.DeepEqual(got, tt.wantWithout) {
+				t.Errorf("Without() = %v, want %v", got, tt.wantWithout)
+			}
+		})
+	}
+
+	relations := []struct {
+		a, b         []string
+		wantSubset   bool
+		wantOverlaps bool
+	}{
+		{a: []string{"user", "-user.user_id"}, b: []string{"user"}, wantSubset: true, wantOverlaps: true},
+		{a: []string{"user"}, b: []string{"user", "-user.user_id"}, wantSubset: false, wantOverlaps: true},
+		{a: []string{"user.name"}, b: []string{"*", "-user.user_id"}, wantSubset: true, wantOverlaps: true},
+		{a: []string{"user.user_id"}, b: []string{"*", "-user.user_id"}, wantSubset: false, wantOverlaps: false},
+		{a: []string{"user", "-user.user_id"}, b: []string{"*", "-user.user_id", "-photo"}, wantSubset: true,
+			wantOverlaps: true},
+		{a: []string{"user.name"}, b: []string{"-user.user_id"}, wantSubset: false, wantOverlaps: false},
+		{a: []string{"user", "-user.name"}, b: []string{"user.name"}, wantSubset: false, wantOverlaps: false},
+	}
+	for _, r := range relations {
+		a, b := NestedMaskFromPaths(r.a), NestedMaskFromPaths(r.b)
+		if got := a.IsSubsetOf(b); got != r.wantSubset {
+			t.Errorf("%v.IsSubsetOf(%v) = %v, want %v", r.a, r.b, got, r.wantSubset)
+		}
+		if got := a.Overlaps(b); got != r.wantOverlaps {
+			t.Errorf("%v.Overlaps(%v) = %v, want %v", r.a, r.b, got, r.wantOverlaps)
+		}
+	}
+}
+
+func TestExclusionPaths_Validate(t *testing.T) {
+	md := (&testproto.Profile{}).ProtoReflect().Descriptor()
+	err := NestedMaskFromPaths([]string{"user", "-user.unknown", "-gallery.0.path.a", "-attributes.*.tags"}).Validate(md)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want a *ValidationError", err)
+	}
+	var got []string
+	for _, pe := range verr.Errors {
+		got = append(got, pe.Path)
+	}
+	want := []string{"-gallery.0.path.a", "-user.unknown"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() paths = %v, want %v", got, want)
+	}
+	if !errors.Is(err, ErrUnknownField) || !errors.Is(err, ErrScalarDescent) {
+		t.Errorf("Validate() error = %v, want %v and %v", err, ErrUnknownField, ErrScalarDescent)
+	}
+}
+
+func TestExclusionPaths_namesAndOverwrite(t *testing.T) {
+	md := (&testproto.Profile{}).ProtoReflect().Descriptor()
+	mask := NestedMaskFromPaths([]string{"gallery", "-loginTimestamps", "-gallery.0.photoId"})
+	want := []string{"-gallery.0.photo_id", "-login_timestamps", "gallery"}
+	if got := mask.ProtoNames(md).Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProtoNames() = %v, want %v", got, want)
+	}
+
+	dst := newExclusionProfile()
+	src := &testproto.Profile{User: &testproto.User{Name: "new name"}, LoginTimestamps: []int64{3}}
+	NestedMaskFromPaths([]string{"*", "-user.user_id", "-login_timestamps"}).Overwrite(dst, src)
+	wantDst := &testproto.Profile{User: &testproto.User{UserId: 1, Name: "new name"}, LoginTimestamps: []int64{1, 2}}
+	if !proto.Equal(dst, wantDst) {
+		t.Errorf("Overwrite() = %v, want %v", dst, wantDst)
+	}
+}
@@ -0,0 +1,171 @@
+package fmutils
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// fieldsDelimiters are the characters that can not appear in an unquoted segment of the fields.
+const fieldsDelimiters = ",()/. \t`"
+
+// ParseFields creates an instance of NestedMask from the fields in Google's partial response syntax returning a
+// *SyntaxError if the fields are malformed.
+//
+// The fields are separated with commas. The subfields of a field are either listed in parentheses or separated with
+// slashes (or dots), and a "*" segment is a wildcard:
+//
+//	items(id,name),nextPageToken
+//	a/b/c,a/d(*)
+//
+// The segments that contain delimiters are quoted with backticks like in ParseNestedMask. Spaces around the segments
+// are ignored. An empty string is an empty mask.
+func ParseFields(fields string) (NestedMask, error) {
+	mask := make(NestedMask)
+	p := &fieldsParser{s: fields}
+	p.skipSpaces()
+	if p.i == len(p.s) {
+		return mask, nil
+	}
+	if err := p.list(mask, nil); err != nil {
+		return nil, err
+	}
+	if p.i < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.i])
+	}
+	return mask, nil
+}
+
+type fieldsParser struct {
+	s string
+	i int
+}
+
+// list parses the comma-separated fields adding them to the mask under the given prefix.
+func (p *fieldsParser) list(mask NestedMask, prefix []string) error {
+	for {
+		segments, err := p.path(prefix)
+		if err != nil {
+			return err
+		}
+		if p.next('(') {
+			if err := p.list(mask, segments); err != nil {
+				return err
+			}
+			if !p.next(')') {
+				return p.errorf("expected ')'")
+			}
+		} else {
+			mask.add(segments)
+		}
+		if !p.next(',') {
+			return nil
+		}
+	}
+}
+
+// path parses the slash-separated segments appending them to a copy of the prefix.
+func (p *fieldsParser) path(prefix []string) ([]string, error) {
+	segments := append([]string(nil), prefix...)
+	for {
+		segment, err := p.segment()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+		if !p.next('/') && !p.next('.') {
+			return segments, nil
+		}
+	}
+}
+
+func (p *fieldsParser) segment() (string, error) {
+	p.skipSpaces()
+	start := p.i
+	if p.i < len(p.s) && p.s[p.i] == '`' {
+		segment, end, err := unquoteSegment(p.s, p.i)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case segment == wildcard:
+			return "", p.errorf("the wildcard can not be quoted")
+		case !utf8.ValidString(segment):
+			return "", p.errorf("invalid UTF-8 in a segment")
+		}
+		p.i = end
+		return segment, nil
+	}
+	for p.i < len(p.s) && !strings.ContainsRune(fieldsDelimiters, rune(p.s[p.i])) {
+		p.i++
+	}
+	switch segment := p.s[start:p.i]; {
+	case segment == "":
+		return "", p.errorf("empty segment")
+	case !utf8.ValidString(segment):
+		p.i = start
+		return "", p.errorf("invalid UTF-8 in a segment")
+	}
+	return p.s[start:p.i], nil
+}
+
+// next skips the spaces and consumes the given delimiter, it reports whether the delimiter is found.
+func (p *fieldsParser) next(delim byte) bool {
+	p.skipSpaces()
+	if p.i < len(p.s) && p.s[p.i] == delim {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *fieldsParser) skipSpaces() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *fieldsParser) errorf(format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Path: p.s, Offset: p.i, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Fields returns the mask in the compact form of Google's partial response syntax.
+//
+// A field with a single subfield is written with a slash, a field with multiple subfields lists them in parentheses,
+// e.g. "a/b,c(d,e)". The fields are sorted. See ParseFields for the syntax.
+// The syntax has no excluded paths, so ErrExclusions is returned for a mask with exclusions rather than widening it.
+func (mask NestedMask) Fields() (string, error) {
+	if mask.hasExclusions() {
+		return "", ErrExclusions
+	}
+	var sb strings.Builder
+	mask.writeFields(&sb)
+	return sb.String(), nil
+}
+
+func (mask NestedMask) writeFields(sb *strings.Builder) {
+	for i, key := range mask.sortedKeys() {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(quoteField(key))
+		switch m := mask[key]; len(m) {
+		case 0:
+		case 1:
+			sb.WriteByte('/')
+			m.writeFields(sb)
+		default:
+			sb.WriteByte('(')
+			m.writeFields(sb)
+			sb.WriteByte(')')
+		}
+	}
+}
+
+// quoteField quotes the segment with backticks if it is not a valid unquoted segment of the fields.
+func quoteField(segment string) string {
+	if segment != "" && !strings.ContainsAny(segment, fieldsDelimiters) {
+		return segment
+	}
+	return "`" + strings.Replace(segment, "`", "``", -1) + "`"
+}
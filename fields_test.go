@@ -0,0 +1,182 @@
+package fmutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  string
+		want    NestedMask
+		wantErr *SyntaxError
+	}{
+		{
+			name:   "empty fields",
+			fields: " ",
+			want:   NestedMask{},
+		},
+		{
+			name:   "sub-selections",
+			fields: "items(id,name),nextPageToken",
+			want: NestedMask{
+				"items":         NestedMask{"id": NestedMask{}, "name": NestedMask{}},
+				"nextPageToken": NestedMask{}},
+		},
+		{
+			name:   "slashes and dots",
+			fields: "a/b/c,a.d,e/f(g/h,i)",
+			want: NestedMask{
+				"a": NestedMask{"b": NestedMask{"c": NestedMask{}}, "d": NestedMask{}},
+				"e": NestedMask{"f": NestedMask{"g": NestedMask{"h": NestedMask{}}, "i": NestedMask{}}}},
+		},
+		{
+			name:   "nested parentheses and spaces",
+			fields: " a ( b ( c , d ) , e ) , f ",
+			want: NestedMask{
+				"a": NestedMask{"b": NestedMask{"c": NestedMask{}, "d": NestedMask{}}, "e": NestedMask{}},
+				"f": NestedMask{}},
+		},
+		{
+			name:   "wildcards",
+			fields: "*,attributes(*/tags/t1),user/*",
+			want: NestedMask{
+				"*":          NestedMask{},
+				"attributes": NestedMask{"*": NestedMask{"tags": NestedMask{"t1": NestedMask{}}}},
+				"user":       NestedMask{"*": NestedMask{}}},
+		},
+		{
+			name:   "whole field takes precedence",
+			fields: "a(b),a,c,c/d",
+			want:   NestedMask{"a": NestedMask{}, "c": NestedMask{}},
+		},
+		{
+			name:   "quoted segments",
+			fields: "labels(`example.com`,`a(b)`/`c,d`)",
+			want: NestedMask{"labels": NestedMask{
+				"example.com": NestedMask{},
+				"a(b)":        NestedMask{"c,d": NestedMask{}}}},
+		},
+		{
+			name:    "empty segment",
+			fields:  "a,,b",
+			wantErr: &SyntaxError{Path: "a,,b", Offset: 2, Msg: "empty segment"},
+		},
+		{
+			name:    "empty parentheses",
+			fields:  "a()",
+			wantErr: &SyntaxError{Path: "a()", Offset: 2, Msg: "empty segment"},
+		},
+		{
+			name:    "trailing slash",
+			fields:  "a/b/",
+			wantErr: &SyntaxError{Path: "a/b/", Offset: 4, Msg: "empty segment"},
+		},
+		{
+			name:    "unbalanced opening parenthesis",
+			fields:  "a(b,c(d)",
+			wantErr: &SyntaxError{Path: "a(b,c(d)", Offset: 8, Msg: "expected ')'"},
+		},
+		{
+			name:    "unbalanced closing parenthesis",
+			fields:  "a(b))",
+			wantErr: &SyntaxError{Path: "a(b))", Offset: 4, Msg: "unexpected ')'"},
+		},
+		{
+			name:    "missing comma",
+			fields:  "a(b)c",
+			wantErr: &SyntaxError{Path: "a(b)c", Offset: 4, Msg: "unexpected 'c'"},
+		},
+		{
+			name:    "unterminated quoted segment",
+			fields:  "a/`b",
+			wantErr: &SyntaxError{Path: "a/`b", Offset: 2, Msg: "unterminated quoted segment"},
+		},
+		{
+			name:    "quoted wildcard",
+			fields:  "a(`*`)",
+			wantErr: &SyntaxError{Path: "a(`*`)", Offset: 2, Msg: "the wildcard can not be quoted"},
+		},
+		{
+			name:    "invalid UTF-8",
+			fields:  "a/`\xff`",
+			wantErr: &SyntaxError{Path: "a/`\xff`", Offset: 2, Msg: "invalid UTF-8 in a segment"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFields(tt.fields)
+			if tt.wantErr != nil {
+				var serr *SyntaxError
+				if !errors.As(err, &serr) || !reflect.DeepEqual(serr, tt.wantErr) {
+					t.Errorf("ParseFields() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFields() error = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFields() = %v, want %v", got, tt.want)
+			}
+			if fields, _ := got.Fields(); !reflect.DeepEqual(mustParseFields(t, fields), got) {
+				t.Errorf("ParseFields(%q) = %v, want %v", fields, mustParseFields(t, fields), got)
+			}
+		})
+	}
+}
+
+func TestNestedMask_Fields(t *testing.T) {
+	tests := []struct {
+		name string
+		mask NestedMask
+		want string
+	}{
+		{
+			name: "empty mask",
+			mask: NestedMask{},
+			want: "",
+		},
+		{
+			name: "sub-selections",
+			mask: NestedMaskFromPaths([]string{"nextPageToken", "items.name", "items.id"}),
+			want: "items(id,name),nextPageToken",
+		},
+		{
+			name: "single subfields",
+			mask: NestedMaskFromPaths([]string{"a.b.c", "a.b.d", "e.f.g"}),
+			want: "a/b(c,d),e/f/g",
+		},
+		{
+			name: "wildcards and quoted segments",
+			mask: NestedMaskFromPaths([]string{"labels.`example.com`", "labels.`a(b)`", "*.*"}),
+			want: "*/*,labels(`a(b)`,`example.com`)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.mask.Fields()
+			if err != nil {
+				t.Fatalf("Fields() error = %v, want nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("Fields() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NestedMaskFromPaths([]string{"user", "-user.name"}).Fields(); err != ErrExclusions {
+		t.Errorf("Fields() error = %v, want %v", err, ErrExclusions)
+	}
+}
+
+func mustParseFields(t *testing.T, fields string) NestedMask {
+	t.Helper()
+	mask, err := ParseFields(fields)
+	if err != nil {
+		t.Fatalf("ParseFields(%q) error = %v", fields, err)
+	}
+	return mask
+}
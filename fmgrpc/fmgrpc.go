@@ -0,0 +1,213 @@
+// Package fmgrpc provides gRPC server interceptors that filter the responses with the field masks of the requests.
+package fmgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/mennanov/fmutils"
+)
+
+const (
+	fieldMaskFullName    protoreflect.FullName    = "google.protobuf.FieldMask"
+	fieldMaskPathsNumber protoreflect.FieldNumber = 1
+)
+
+// Option configures the interceptors.
+type Option func(*config)
+
+type config struct {
+	fieldNames []protoreflect.Name
+	maskOpts   []fmutils.Option
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if len(c.fieldNames) == 0 {
+		c.fieldNames = []protoreflect.Name{"read_mask", "field_mask"}
+	}
+	return c
+}
+
+// WithFieldNames sets the names of the request fields that hold the read mask, "read_mask" and "field_mask" by default.
+//
+// The first google.protobuf.FieldMask field with one of the given names is used. The other google.protobuf.FieldMask
+// fields, e.g. the update_mask of the Update methods, are never used to filter the responses.
+func WithFieldNames(names ...string) Option {
+	return func(c *config) {
+		for _, name := range names {
+			c.fieldNames = append(c.fieldNames, protoreflect.Name(name))
+		}
+	}
+}
+
+// WithMaskOptions sets the options the masks are applied to the responses with, e.g. fmutils.WithAnyResolver.
+func WithMaskOptions(opts ...fmutils.Option) Option {
+	return func(c *config) {
+		c.maskOpts = append(c.maskOpts, opts...)
+	}
+}
+
+// UnaryServerInterceptor returns a unary server interceptor that filters the response with the read mask of the
+// request.
+//
+// The response is replaced with its filtered copy, so that the handlers may return the shared or cached messages. If
+// the request has no read mask or the mask is empty, the response is returned as is. A malformed mask or a mask that
+// does not match the response type is reported as codes.InvalidArgument with the errdetails.BadRequest details
+// listing the invalid paths.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	c := newConfig(opts)
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		mask, err := c.readMask(req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		return mask.apply(resp, c.maskOpts)
+	}
+}
+
+// StreamServerInterceptor returns a stream server interceptor that filters every sent message with the read mask of
+// the received request.
+//
+// The filtered copies of the messages are sent, the messages themselves are left untouched.
+// For the client and bidirectional streams the read mask of the most recently received request that has one is used.
+// The errors are reported like UnaryServerInterceptor does by the RecvMsg and SendMsg methods of the stream.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	c := newConfig(opts)
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, config: c})
+	}
+}
+
+// serverStream filters the sent messages with the read mask of the received requests.
+type serverStream struct {
+	grpc.ServerStream
+	config *config
+
+	mu   sync.Mutex
+	mask *readMask
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	mask, err := s.config.readMask(m)
+	if err != nil {
+		return err
+	}
+	if mask != nil {
+		s.mu.Lock()
+		s.mask = mask
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	mask := s.mask
+	s.mu.Unlock()
+	filtered, err := mask.apply(m, s.config.maskOpts)
+	if err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(filtered)
+}
+
+// readMask is the read mask of a request.
+type readMask struct {
+	// field is the name of the request field the mask is read from.
+	field string
+	mask  fmutils.NestedMask
+}
+
+// readMask returns the read mask of the request, nil if the request has no read mask.
+func (c *config) readMask(req interface{}) (*readMask, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil, nil
+	}
+	rft := msg.ProtoReflect()
+	fd := c.maskField(rft.Descriptor())
+	if fd == nil || !rft.Has(fd) {
+		return nil, nil
+	}
+	fm := rft.Get(fd).Message()
+	list := fm.Get(fm.Descriptor().Fields().ByNumber(fieldMaskPathsNumber)).List()
+	paths := make([]string, list.Len())
+	for i := range paths {
+		paths[i] = list.Get(i).String()
+	}
+	mask, err := fmutils.ParseNestedMask(paths)
+	if err != nil {
+		return nil, invalidMask(string(fd.Name()), err, []string{err.Error()})
+	}
+	return &readMask{field: string(fd.Name()), mask: mask}, nil
+}
+
+// maskField returns the request field that holds the read mask, nil if there is no such field.
+func (c *config) maskField(md protoreflect.MessageDescriptor) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	for _, name := range c.fieldNames {
+		if fd := fields.ByName(name); fd != nil && isFieldMask(fd) {
+			return fd
+		}
+	}
+	return nil
+}
+
+func isFieldMask(fd protoreflect.FieldDescriptor) bool {
+	return !fd.IsList() && fd.Message() != nil && fd.Message().FullName() == fieldMaskFullName
+}
+
+// apply returns a copy of the response filtered with the mask, the response itself is left untouched.
+func (m *readMask) apply(resp interface{}, opts []fmutils.Option) (interface{}, error) {
+	msg, ok := resp.(proto.Message)
+	if m == nil || len(m.mask) == 0 || !ok {
+		return resp, nil
+	}
+	filtered, err := m.mask.FilterCopyChecked(msg, opts...)
+	var verr *fmutils.ValidationError
+	if errors.As(err, &verr) {
+		descriptions := make([]string, len(verr.Errors))
+		for i, pe := range verr.Errors {
+			descriptions[i] = pe.Error()
+		}
+		return nil, invalidMask(m.field, err, descriptions)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "fmgrpc: can not apply %s: %v", m.field, err)
+	}
+	return filtered, nil
+}
+
+// invalidMask returns a codes.InvalidArgument status error with a violation of the field for every description.
+func invalidMask(field string, err error, descriptions []string) error {
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("fmgrpc: invalid %s: %v", field, err))
+	br := &errdetails.BadRequest{}
+	for _, d := range descriptions {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field, Description: d})
+	}
+	if ds, err := st.WithDetails(br); err == nil {
+		st = ds
+	}
+	return st.Err()
+}
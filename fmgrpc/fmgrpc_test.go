@@ -0,0 +1,288 @@
+package fmgrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+var testProfile = &testproto.Profile{
+	User:            &testproto.User{UserId: 1, Name: "name"},
+	Photo:           &testproto.Photo{PhotoId: 2, Path: "path"},
+	LoginTimestamps: []int64{1, 2},
+	Gallery:         []*testproto.Photo{{PhotoId: 3, Path: "path 3"}},
+}
+
+// testServiceDesc describes a service with unary and server streaming methods that return the shared testProfile.
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: "testproto.ProfileService",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetProfile",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &testproto.GetProfileRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return testProfile, nil
+				}
+				return interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/testproto.ProfileService/GetProfile"}, handler)
+			},
+		},
+		{
+			MethodName: "UpdateProfile",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &testproto.UpdateProfileRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return testProfile, nil
+				}
+				return interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/testproto.ProfileService/UpdateProfile"}, handler)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListProfiles",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &testproto.GetProfileRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				for i := 0; i < 2; i++ {
+					if err := stream.SendMsg(testProfile); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	},
+}
+
+// dial starts an in-process server with the interceptors created with the given options and connects to it.
+func dial(t *testing.T, opts ...Option) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(opts...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(opts...)),
+	)
+	srv.RegisterService(&testServiceDesc, struct{}{})
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		readMask *fieldmaskpb.FieldMask
+		want     *testproto.Profile
+	}{
+		{
+			name:     "no read mask",
+			readMask: nil,
+			want:     testProfile,
+		},
+		{
+			name:     "empty read mask",
+			readMask: &fieldmaskpb.FieldMask{},
+			want:     testProfile,
+		},
+		{
+			name:     "read mask",
+			readMask: &fieldmaskpb.FieldMask{Paths: []string{"user.name", "login_timestamps"}},
+			want: &testproto.Profile{
+				User:            &testproto.User{Name: "name"},
+				LoginTimestamps: []int64{1, 2},
+			},
+		},
+	}
+	conn := dial(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &testproto.Profile{}
+			err := conn.Invoke(context.Background(), "/testproto.ProfileService/GetProfile",
+				&testproto.GetProfileRequest{UserId: 1, ReadMask: tt.readMask}, got)
+			if err != nil {
+				t.Fatalf("Invoke() error = %v", err)
+			}
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("Invoke() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if testProfile.GetPhoto() == nil {
+		t.Errorf("the handler response is modified: %v", testProfile)
+	}
+}
+
+func TestUnaryServerInterceptor_invalidMask(t *testing.T) {
+	tests := []struct {
+		name       string
+		paths      []string
+		violations int
+	}{
+		{
+			name:       "malformed path",
+			paths:      []string{"user..name"},
+			violations: 1,
+		},
+		{
+			name:       "unknown fields",
+			paths:      []string{"user.name", "user.email", "unknown"},
+			violations: 2,
+		},
+		{
+			name:       "index out of range",
+			paths:      []string{"gallery.0.path", "gallery.5", "gallery.-2"},
+			violations: 2,
+		},
+	}
+	conn := dial(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := conn.Invoke(context.Background(), "/testproto.ProfileService/GetProfile",
+				&testproto.GetProfileRequest{ReadMask: &fieldmaskpb.FieldMask{Paths: tt.paths}}, &testproto.Profile{})
+			assertInvalidArgument(t, err, tt.violations)
+		})
+	}
+}
+
+func TestUnaryServerInterceptor_WithFieldNames(t *testing.T) {
+	conn := dial(t, WithFieldNames("read_mask", "fieldmask"))
+	got := &testproto.Profile{}
+	err := conn.Invoke(context.Background(), "/testproto.ProfileService/UpdateProfile",
+		&testproto.UpdateProfileRequest{Fieldmask: &fieldmaskpb.FieldMask{Paths: []string{"photo.path"}}}, got)
+	if err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	want := &testproto.Profile{Photo: &testproto.Photo{Path: "path"}}
+	if !proto.Equal(got, want) {
+		t.Errorf("Invoke() = %v, want %v", got, want)
+	}
+
+	for _, opts := range [][]Option{nil, {WithFieldNames("read_mask")}} {
+		conn = dial(t, opts...)
+		got = &testproto.Profile{}
+		err = conn.Invoke(context.Background(), "/testproto.ProfileService/UpdateProfile",
+			&testproto.UpdateProfileRequest{Fieldmask: &fieldmaskpb.FieldMask{Paths: []string{"photo.path"}}}, got)
+		if err != nil {
+			t.Fatalf("Invoke() error = %v", err)
+		}
+		if !proto.Equal(got, testProfile) {
+			t.Errorf("Invoke() = %v, want %v", got, testProfile)
+		}
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	conn := dial(t)
+	stream, err := conn.NewStream(context.Background(), &testServiceDesc.Streams[0],
+		"/testproto.ProfileService/ListProfiles")
+	if err != nil {
+		t.Fatalf("NewStream() error = %v", err)
+	}
+	if err := stream.SendMsg(&testproto.GetProfileRequest{
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"user.user_id"}},
+	}); err != nil {
+		t.Fatalf("SendMsg() error = %v", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend() error = %v", err)
+	}
+
+	var got []*testproto.Profile
+	for {
+		p := &testproto.Profile{}
+		err := stream.RecvMsg(p)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("RecvMsg() error = %v", err)
+		}
+		got = append(got, p)
+	}
+	want := &testproto.Profile{User: &testproto.User{UserId: 1}}
+	if len(got) != 2 || !proto.Equal(got[0], want) || !proto.Equal(got[1], want) {
+		t.Errorf("RecvMsg() = %v, want 2 x %v", got, want)
+	}
+	if testProfile.GetUser().GetName() == "" {
+		t.Errorf("SendMsg() modified the sent message: %v", testProfile)
+	}
+}
+
+func TestStreamServerInterceptor_invalidMask(t *testing.T) {
+	conn := dial(t)
+	stream, err := conn.NewStream(context.Background(), &testServiceDesc.Streams[0],
+		"/testproto.ProfileService/ListProfiles")
+	if err != nil {
+		t.Fatalf("NewStream() error = %v", err)
+	}
+	if err := stream.SendMsg(&testproto.GetProfileRequest{
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"user.email"}},
+	}); err != nil {
+		t.Fatalf("SendMsg() error = %v", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend() error = %v", err)
+	}
+	assertInvalidArgument(t, stream.RecvMsg(&testproto.Profile{}), 1)
+}
+
+func assertInvalidArgument(t *testing.T, err error, violations int) {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("error = %v, want %v", err, codes.InvalidArgument)
+	}
+	var got []*errdetails.BadRequest_FieldViolation
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			got = append(got, br.FieldViolations...)
+		}
+	}
+	if len(got) != violations {
+		t.Fatalf("violations = %v, want %d", got, violations)
+	}
+	for _, v := range got {
+		if v.Field != "read_mask" {
+			t.Errorf("violation field = %q, want %q", v.Field, "read_mask")
+		}
+	}
+}
@@ -0,0 +1,19 @@
+module github.com/mennanov/fmutils/fmgrpc
+
+go 1.19
+
+require (
+	github.com/mennanov/fmutils v0.0.0
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1
+	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.30.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
+
+replace github.com/mennanov/fmutils => ../
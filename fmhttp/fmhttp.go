@@ -0,0 +1,130 @@
+// Package fmhttp provides net/http middleware that filters the proto responses with the fields query parameter.
+//
+// The fields query parameter lists the fields to return in Google's partial response syntax, e.g.
+// "user.name,photo(path,dimensions/width)". See fmutils.ParseFields for the syntax.
+package fmhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mennanov/fmutils"
+)
+
+// Option configures the middleware.
+type Option func(*config)
+
+type config struct {
+	parameter string
+	maskOpts  []fmutils.Option
+	marshal   protojson.MarshalOptions
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{parameter: "fields", maskOpts: []fmutils.Option{fmutils.WithJSONNames()}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithQueryParameter sets the name of the query parameter the mask is read from, "fields" by default.
+func WithQueryParameter(name string) Option {
+	return func(c *config) {
+		c.parameter = name
+	}
+}
+
+// WithMaskOptions sets the options the mask is applied to the responses with, e.g. fmutils.WithAnyResolver.
+//
+// The options are added to fmutils.WithJSONNames which the mask is always applied with, so that the clients can list
+// the fields by the names they see in the JSON responses as well as by their proto names.
+func WithMaskOptions(opts ...fmutils.Option) Option {
+	return func(c *config) {
+		c.maskOpts = append(c.maskOpts, opts...)
+	}
+}
+
+// WithMarshalOptions sets the options the responses are encoded with by Write.
+func WithMarshalOptions(mo protojson.MarshalOptions) Option {
+	return func(c *config) {
+		c.marshal = mo
+	}
+}
+
+type contextKey struct{}
+
+// fieldsMask is the mask of a request stored in its context.
+type fieldsMask struct {
+	mask   fmutils.NestedMask
+	config *config
+}
+
+// Middleware returns a handler that reads the mask from the fields query parameter and stores it in the request
+// context for Write.
+//
+// Requests with a malformed fields query parameter are rejected with http.StatusBadRequest.
+func Middleware(next http.Handler, opts ...Option) http.Handler {
+	c := newConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fm := &fieldsMask{config: c}
+		if fields := r.URL.Query().Get(c.parameter); fields != "" {
+			mask, err := fmutils.ParseFields(fields)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("fmhttp: invalid %s: %v", c.parameter, err), http.StatusBadRequest)
+				return
+			}
+			fm.mask = mask
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, fm)))
+	})
+}
+
+// FromContext returns the mask stored in the context by Middleware.
+//
+// It returns false if the request has no fields query parameter.
+func FromContext(ctx context.Context) (fmutils.NestedMask, bool) {
+	fm, ok := ctx.Value(contextKey{}).(*fieldsMask)
+	if !ok || len(fm.mask) == 0 {
+		return nil, false
+	}
+	return fm.mask, true
+}
+
+// Write filters the msg with the mask of the request and writes it to w encoded with protojson.
+//
+// A filtered copy of the msg is written leaving the msg untouched, so the same msg can be shared by concurrent
+// requests. If the mask does not match the msg type then http.StatusBadRequest is written instead. If the request has
+// not been handled by Middleware or has no fields query parameter then the msg is written as is.
+func Write(w http.ResponseWriter, r *http.Request, msg proto.Message) {
+	fm, ok := r.Context().Value(contextKey{}).(*fieldsMask)
+	if !ok {
+		fm = &fieldsMask{config: newConfig(nil)}
+	}
+	if len(fm.mask) != 0 {
+		filtered, err := fm.mask.FilterCopyChecked(msg, fm.config.maskOpts...)
+		var verr *fmutils.ValidationError
+		if errors.As(err, &verr) {
+			http.Error(w, fmt.Sprintf("fmhttp: invalid %s: %v", fm.config.parameter, err), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("fmhttp: can not apply %s: %v", fm.config.parameter, err),
+				http.StatusInternalServerError)
+			return
+		}
+		msg = filtered
+	}
+	b, err := fm.config.marshal.Marshal(msg)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("fmhttp: can not encode the response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
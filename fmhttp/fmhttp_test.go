@@ -0,0 +1,172 @@
+package fmhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+var testProfile = &testproto.Profile{
+	User: &testproto.User{UserId: 1, Name: "name"},
+	Photo: &testproto.Photo{
+		PhotoId: 2, Path: "path", Dimensions: &testproto.Dimensions{Width: 100, Height: 120},
+	},
+	LoginTimestamps: []int64{1, 2},
+	Gallery:         []*testproto.Photo{{PhotoId: 3, Path: "path 3"}},
+}
+
+// serve sends a GET request with the given query to a handler that writes the shared testProfile and checks that
+// testProfile is left untouched.
+func serve(t *testing.T, query string, opts ...Option) *httptest.ResponseRecorder {
+	t.Helper()
+	want := proto.Clone(testProfile)
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, testProfile)
+	}), opts...)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profile?"+query, nil))
+	if !proto.Equal(testProfile, want) {
+		t.Errorf("Write() modified the message: %v, want %v", testProfile, want)
+	}
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields string
+		want   *testproto.Profile
+	}{
+		{
+			name:   "no fields",
+			fields: "",
+			want:   testProfile,
+		},
+		{
+			name:   "dotted paths",
+			fields: "user.name,photo.dimensions.width",
+			want: &testproto.Profile{
+				User:  &testproto.User{Name: "name"},
+				Photo: &testproto.Photo{Dimensions: &testproto.Dimensions{Width: 100}},
+			},
+		},
+		{
+			name:   "partial response syntax",
+			fields: "login_timestamps, photo(path,dimensions/height)",
+			want: &testproto.Profile{
+				Photo:           &testproto.Photo{Path: "path", Dimensions: &testproto.Dimensions{Height: 120}},
+				LoginTimestamps: []int64{1, 2},
+			},
+		},
+		{
+			name:   "JSON names",
+			fields: "loginTimestamps,user/user_id,photo/photoId",
+			want: &testproto.Profile{
+				User:            &testproto.User{UserId: 1},
+				Photo:           &testproto.Photo{PhotoId: 2},
+				LoginTimestamps: []int64{1, 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, url.Values{"fields": {tt.fields}}.Encode())
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want %q", ct, "application/json")
+			}
+			got := &testproto.Profile{}
+			if err := protojson.Unmarshal(rec.Body.Bytes(), got); err != nil {
+				t.Fatalf("protojson.Unmarshal() error = %v", err)
+			}
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("response = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrite_sharedMessage(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, testProfile)
+	}))
+	want := proto.Clone(testProfile)
+	var wg sync.WaitGroup
+	for _, fields := range []string{"user/name", "photo", "login_timestamps", "gallery/0/path"} {
+		wg.Add(1)
+		go func(fields string) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/profile?fields="+fields, nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+		}(fields)
+	}
+	wg.Wait()
+	if !proto.Equal(testProfile, want) {
+		t.Errorf("Write() modified the shared message: %v, want %v", testProfile, want)
+	}
+}
+
+func TestMiddleware_badRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields string
+	}{
+		{name: "unknown field", fields: "user.email"},
+		{name: "descending into a scalar", fields: "login_timestamps(value)"},
+		{name: "unbalanced parentheses", fields: "photo(path"},
+		{name: "unexpected closing parenthesis", fields: "photo)"},
+		{name: "empty field", fields: "user,,photo"},
+		{name: "index out of range", fields: "gallery/5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, url.Values{"fields": {tt.fields}}.Encode())
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestMiddleware_options(t *testing.T) {
+	rec := serve(t, "mask=user.user_id&fields=photo", WithQueryParameter("mask"),
+		WithMarshalOptions(protojson.MarshalOptions{UseProtoNames: true}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	want := map[string]interface{}{"user": map[string]interface{}{"user_id": "1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("response = %v, want %v", got, want)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	var got []string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mask, ok := FromContext(r.Context()); ok {
+			got = mask.Paths()
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/profile?fields=a(b,c),d", nil))
+	if want := []string{"a.b", "a.c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FromContext() = %v, want %v", got, want)
+	}
+}
@@ -1,108 +1,220 @@
 package fmutils
 
 import (
+	"errors"
+	"sort"
+
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 // Filter keeps the msg fields that are listed in the paths and clears all the rest.
 //
 // This is a handy wrapper for NestedMask.Filter method.
 // If the same paths are used to process multiple proto messages use NestedMask.Filter method directly.
-func Filter(msg proto.Message, paths []string) {
-	NestedMaskFromPaths(paths).Filter(msg)
+// Malformed paths are split on dots as is, see NestedMaskFromPaths. Use FilterChecked to get an error instead.
+func Filter(msg proto.Message, paths []string, opts ...Option) {
+	NestedMaskFromPaths(paths).Filter(msg, opts...)
 }
 
 // Prune clears all the fields listed in paths from the given msg.
 //
 // This is a handy wrapper for NestedMask.Prune method.
 // If the same paths are used to process multiple proto messages use NestedMask.Filter method directly.
-func Prune(msg proto.Message, paths []string) {
-	NestedMaskFromPaths(paths).Prune(msg)
+// Malformed paths are split on dots as is, see NestedMaskFromPaths. Use PruneChecked to get an error instead.
+func Prune(msg proto.Message, paths []string, opts ...Option) {
+	NestedMaskFromPaths(paths).Prune(msg, opts...)
 }
 
 // NestedMask represents a field mask as a recursive map.
+//
+// The "*" key is a wildcard that matches every field of a message or every key of a map. The mask for a field or a map
+// key that is listed along with the wildcard is the union of their masks.
+// Map keys are parsed according to the type of the map key, e.g. "42", "+42" and "042" denote the same key of a map
+// with integer keys. The keys that can not be parsed do not match any entry.
+// The integer segments under a repeated field are the indices of its elements, e.g. "gallery.0.path", a negative index
+// counts from the end of the list. The mask of an element is the union of the masks for its index and for all the
+// elements. The elements of such a list that are not listed are dropped by Filter and kept by Prune, the indices out
+// of range are ignored or reported by the checked methods.
+// The "[field=value]" segments under a repeated message field select the elements which field has the given value,
+// e.g. "gallery[photo_id=2].path", they are treated like the indices.
+// A reserved key that no path segment can produce holds the paths that are excluded from the mask, e.g.
+// "-profile.user.user_id": a field or a map key that is excluded as a whole is not listed, the exclusions of its
+// subfields are carried to its mask. The exclusions only carve the paths out of the listed ones, so a mask that has
+// only the exclusions lists nothing, list "*" along with them to list everything except them.
 type NestedMask map[string]NestedMask
 
+const (
+	// wildcard is the path segment that matches every field of a message or every key of a map.
+	wildcard = "*"
+	// exclusion is the key of the mask that holds the excluded paths. It is not valid UTF-8, so it never clashes with
+	// the parsed path segments, the field names or the keys of the maps with proto3 string keys.
+	exclusion = "\xff"
+	// exclusionPrefix is the prefix of an excluded path.
+	exclusionPrefix = "-"
+)
+
+// ErrExclusions is returned when a mask with the excluded paths is converted to a form that can not represent them.
+var ErrExclusions = errors.New("the mask has excluded paths")
+
 // NestedMaskFromPaths creates an instance of NestedMask for the given paths.
+//
+// A path that lists a field as a whole takes precedence over the paths that list its subfields. The paths with the
+// leading '-' are excluded from the mask.
+// Empty path segments are skipped. A malformed path, e.g. "labels.`a" or "labels.a[b", is not dropped but split on
+// dots as is, so that its segments are taken literally like the paths without quoting are. Use ParseNestedMask to get
+// an error instead. See ParseNestedMask for the path syntax.
 func NestedMaskFromPaths(paths []string) NestedMask {
 	mask := make(NestedMask)
 	for _, path := range paths {
-		curr := mask
-		var letters []rune
-		for _, letter := range path {
-			if letter == '.' {
-				if len(letters) == 0 {
-					continue
-				}
+		segments, excluded, _ := parsePath(path, true)
+		mask.addPath(segments, excluded)
+	}
 
-				key := string(letters)
-				c, ok := curr[key]
-				if !ok {
-					c = make(NestedMask)
-					curr[key] = c
-				}
-				curr = c
-				letters = nil
-				continue
-			}
-			letters = append(letters, letter)
-		}
-		if len(letters) != 0 {
-			key := string(letters)
-			if _, ok := curr[key]; !ok {
-				curr[key] = make(NestedMask)
-			}
+	return mask
+}
+
+// NestedMaskFromFieldMask creates an instance of NestedMask for the given field mask.
+func NestedMaskFromFieldMask(fm *fieldmaskpb.FieldMask) NestedMask {
+	return NestedMaskFromPaths(fm.GetPaths())
+}
+
+// Paths returns the sorted normalized list of paths in the mask.
+//
+// This is the inverse of NestedMaskFromPaths, an empty mask results in an empty list.
+// The segments that are not valid identifiers, e.g. map keys with dots, are quoted with backticks. The excluded paths
+// have the leading '-'.
+func (mask NestedMask) Paths() []string {
+	if len(mask) == 0 {
+		return nil
+	}
+	includes, excludes := mask.split()
+	var paths []string
+	if len(includes) != 0 {
+		paths = includes.appendPaths(nil, nil)
+	}
+	if len(excludes) != 0 {
+		for _, p := range excludes.appendPaths(nil, nil) {
+			paths = append(paths, exclusionPrefix+p)
 		}
 	}
+	sort.Strings(paths)
+	return paths
+}
 
-	return mask
+// FieldMask returns a field mask with the sorted normalized list of paths in the mask.
+//
+// A field mask can not have the excluded paths, so ErrExclusions is returned for a mask with exclusions rather than
+// sending them downstream as invalid paths. Use NestedMask.Subtract to remove paths from a mask without exclusions.
+func (mask NestedMask) FieldMask() (*fieldmaskpb.FieldMask, error) {
+	if mask.hasExclusions() {
+		return nil, ErrExclusions
+	}
+	return &fieldmaskpb.FieldMask{Paths: mask.Paths()}, nil
 }
 
 // Filter keeps the msg fields that are listed in the paths and clears all the rest.
 //
-// If the mask is empty then all the fields are kept.
+// If the mask is empty then all the fields are kept. The unknown fields of the messages the mask lists some of the
+// fields of are cleared unless the WithUnknownFields option is given.
 // Paths are assumed to be valid and normalized otherwise the function may panic.
 // See google.golang.org/protobuf/types/known/fieldmaskpb for details.
-func (mask NestedMask) Filter(msg proto.Message) {
+// Use NestedMask.FilterChecked for masks that come from untrusted sources.
+func (mask NestedMask) Filter(msg proto.Message, opts ...Option) {
+	rft, o := msg.ProtoReflect(), newOptions(opts)
+	_ = o.protoNames(mask, rft.Descriptor()).filter(rft, o)
+}
+
+func (mask NestedMask) filter(rft protoreflect.Message, o *options) error {
 	if len(mask) == 0 {
-		return
+		return nil
 	}
 
-	rft := msg.ProtoReflect()
-	rft.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
-		m, ok := mask[string(fd.Name())]
+	var err error
+	o.filterUnknown(rft)
+	o.rangeFields(rft, func(fd protoreflect.FieldDescriptor) bool {
+		m, ok := mask.child(string(fd.Name()))
 		if ok {
 			if len(m) == 0 {
 				return true
 			}
 
 			if fd.IsMap() {
+				m := m.withMapKeys(fd.MapKey())
 				xmap := rft.Get(fd).Map()
-				xmap.Range(func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
-					if mi, ok := m[mk.String()]; ok {
+				o.rangeMap(xmap, func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
+					if mi, ok := m.child(mk.String()); ok {
 						if i, ok := mv.Interface().(protoreflect.Message); ok && len(mi) > 0 {
-							mi.Filter(i.Interface())
+							if o.apply(i, mi.filterMessage, &err, string(fd.Name()), mk.String()) {
+								o.clearEntry(xmap, fd, mk)
+							}
 						}
 					} else {
-						xmap.Clear(mk)
+						o.clearEntry(xmap, fd, mk)
 					}
 
 					return true
 				})
 			} else if fd.IsList() {
-				list := rft.Get(fd).List()
-				for i := 0; i < list.Len(); i++ {
-					m.Filter(list.Get(i).Message().Interface())
-				}
+				o.enter(string(fd.Name()))
+				err = firstError(err, m.filterList(fd, rft.Mutable(fd).List(), o))
+				o.leave(1)
 			} else if fd.Kind() == protoreflect.MessageKind {
-				m.Filter(rft.Get(fd).Message().Interface())
+				if o.apply(rft.Get(fd).Message(), m.filterMessage, &err, string(fd.Name())) {
+					o.clearField(rft, fd)
+				}
 			}
 		} else {
-			rft.Clear(fd)
+			o.clearField(rft, fd)
 		}
 		return true
 	})
+	return err
+}
+
+// filterMessage filters the message which is a singular field value, a list element or a map value.
+func (mask NestedMask) filterMessage(m protoreflect.Message, o *options) error {
+	if o.resolvesAny(m.Descriptor()) {
+		return o.applyToAny(m, mask, NestedMask.filter)
+	}
+	return mask.filter(m, o)
+}
+
+// filterUnknown clears the unknown fields of the message being filtered unless the WithUnknownFields option is given.
+func (o *options) filterUnknown(m protoreflect.Message) {
+	if !o.keepUnknown && len(m.GetUnknown()) != 0 {
+		o.clearUnknown(m)
+	}
+}
+
+// apply applies fn to the message which is a field value, a list element or a map value and records its error in err.
+// The path segments of the message relative to the message being processed are given for the Report.
+//
+// It reports whether the message is left empty by fn and should be cleared if the WithClearEmpty option is given.
+func (o *options) apply(m protoreflect.Message, fn func(protoreflect.Message, *options) error, err *error,
+	segments ...string) bool {
+	wasEmpty := o.clearEmpty && isEmpty(m)
+	o.enter(segments...)
+	*err = firstError(*err, fn(m, o))
+	o.leave(len(segments))
+	return o.clearEmpty && !wasEmpty && isEmpty(m)
+}
+
+// leftEmpty reports whether the copy of the non-empty src message is empty and should not be set if the
+// WithClearEmpty option is given.
+func (o *options) leftEmpty(dst, src protoreflect.Message) bool {
+	return o.clearEmpty && isEmpty(dst) && !isEmpty(src)
+}
+
+// isEmpty reports whether the message has no populated fields and no unknown fields.
+func isEmpty(m protoreflect.Message) bool {
+	empty := len(m.GetUnknown()) == 0
+	m.Range(func(protoreflect.FieldDescriptor, protoreflect.Value) bool {
+		empty = false
+		return false
+	})
+	return empty
 }
 
 // Prune clears all the fields listed in paths from the given msg.
@@ -111,42 +223,183 @@ func (mask NestedMask) Filter(msg proto.Message) {
 // This operation is the opposite of NestedMask.Filter.
 // Paths are assumed to be valid and normalized otherwise the function may panic.
 // See google.golang.org/protobuf/types/known/fieldmaskpb for details.
-func (mask NestedMask) Prune(msg proto.Message) {
+// Use NestedMask.PruneChecked for masks that come from untrusted sources.
+func (mask NestedMask) Prune(msg proto.Message, opts ...Option) {
+	rft, o := msg.ProtoReflect(), newOptions(opts)
+	_ = o.protoNames(mask, rft.Descriptor()).prune(rft, o)
+}
+
+func (mask NestedMask) prune(rft protoreflect.Message, o *options) error {
 	if len(mask) == 0 {
-		return
+		return nil
 	}
 
-	rft := msg.ProtoReflect()
-	rft.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
-		m, ok := mask[string(fd.Name())]
+	var err error
+	o.rangeFields(rft, func(fd protoreflect.FieldDescriptor) bool {
+		m, ok := mask.child(string(fd.Name()))
 		if ok {
 			if len(m) == 0 {
-				rft.Clear(fd)
+				o.clearField(rft, fd)
 				return true
 			}
 
 			if fd.IsMap() {
+				m := m.withMapKeys(fd.MapKey())
 				xmap := rft.Get(fd).Map()
-				xmap.Range(func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
-					if mi, ok := m[mk.String()]; ok {
+				o.rangeMap(xmap, func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
+					if mi, ok := m.child(mk.String()); ok {
 						if i, ok := mv.Interface().(protoreflect.Message); ok && len(mi) > 0 {
-							mi.Prune(i.Interface())
+							if o.apply(i, mi.pruneMessage, &err, string(fd.Name()), mk.String()) {
+								o.clearEntry(xmap, fd, mk)
+							}
 						} else {
-							xmap.Clear(mk)
+							o.clearEntry(xmap, fd, mk)
 						}
 					}
 
 					return true
 				})
 			} else if fd.IsList() {
-				list := rft.Get(fd).List()
-				for i := 0; i < list.Len(); i++ {
-					m.Prune(list.Get(i).Message().Interface())
-				}
+				o.enter(string(fd.Name()))
+				err = firstError(err, m.pruneList(fd, rft.Mutable(fd).List(), o))
+				o.leave(1)
 			} else if fd.Kind() == protoreflect.MessageKind {
-				m.Prune(rft.Get(fd).Message().Interface())
+				if o.apply(rft.Get(fd).Message(), m.pruneMessage, &err, string(fd.Name())) {
+					o.clearField(rft, fd)
+				}
 			}
 		}
 		return true
 	})
+	return err
+}
+
+// pruneMessage prunes the message which is a singular field value, a list element or a map value.
+func (mask NestedMask) pruneMessage(m protoreflect.Message, o *options) error {
+	if o.resolvesAny(m.Descriptor()) {
+		return o.applyToAny(m, mask, NestedMask.prune)
+	}
+	return mask.prune(m, o)
+}
+
+// child returns the mask for the given field name or map key taking the wildcard and the exclusions into account.
+func (mask NestedMask) child(key string) (NestedMask, bool) {
+	excludes, hasExclusions := mask[exclusion]
+	m, ok := mask.included(key)
+	if !ok || !hasExclusions {
+		return m, ok
+	}
+	xm, excluded := excludes.child(key)
+	switch {
+	case !excluded:
+		return m, true
+	case len(xm) == 0:
+		return nil, false
+	default:
+		return m.exclude(xm), true
+	}
+}
+
+// included returns the mask for the given field name or map key taking the wildcard into account.
+func (mask NestedMask) included(key string) (NestedMask, bool) {
+	if key == exclusion {
+		return nil, false
+	}
+	m, ok := mask[key]
+	w, hasWildcard := mask[wildcard]
+	switch {
+	case !hasWildcard:
+		return m, ok
+	case !ok || len(w) == 0:
+		return w, true
+	case len(m) == 0:
+		return m, true
+	default:
+		return m.Union(w), true
+	}
+}
+
+// exclude returns a copy of the mask with the paths of the given mask excluded, the mask itself is not modified.
+//
+// An empty mask that lists the whole field gets the wildcard, so that the result lists everything except the excluded
+// paths.
+func (mask NestedMask) exclude(excludes NestedMask) NestedMask {
+	result := make(NestedMask, len(mask)+1)
+	for key, m := range mask {
+		result[key] = m
+	}
+	if len(mask) == 0 {
+		result[wildcard] = NestedMask{}
+	}
+	if x, ok := mask[exclusion]; ok {
+		excludes = x.Union(excludes)
+	}
+	result[exclusion] = excludes
+	return result
+}
+
+// split splits the mask into the included and the excluded paths.
+func (mask NestedMask) split() (includes, excludes NestedMask) {
+	excludes, ok := mask[exclusion]
+	if !ok {
+		return mask, nil
+	}
+	includes = make(NestedMask, len(mask)-1)
+	for key, m := range mask {
+		if key != exclusion {
+			includes[key] = m
+		}
+	}
+	return includes, excludes
+}
+
+// withMapKeys returns the mask of a map field with the keys in the canonical form of the map key type, so that
+// they can be matched against protoreflect.MapKey.String.
+//
+// E.g. for a map with integer keys "+042" becomes "42". The keys that can not be parsed are dropped.
+func (mask NestedMask) withMapKeys(kd protoreflect.FieldDescriptor) NestedMask {
+	if kd.Kind() == protoreflect.StringKind {
+		return mask
+	}
+	result := make(NestedMask, len(mask))
+	for key, m := range mask {
+		if key == exclusion {
+			result[key] = m.withMapKeys(kd)
+			continue
+		}
+		if key != wildcard {
+			mk, err := parseMapKey(kd, key)
+			if err != nil {
+				continue
+			}
+			key = mk.String()
+		}
+		result.unionChild(key, m)
+	}
+	return result
+}
+
+// appendPaths appends all the full paths of the mask prefixed with the given segments to dst.
+func (mask NestedMask) appendPaths(dst []string, prefix []string) []string {
+	if len(mask) == 0 {
+		return append(dst, joinPath(prefix))
+	}
+	for _, key := range mask.sortedKeys() {
+		dst = mask[key].appendPaths(dst, appendSegment(prefix, key))
+	}
+	return dst
+}
+
+func (mask NestedMask) sortedKeys() []string {
+	keys := make([]string, 0, len(mask))
+	for key := range mask {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// appendSegment returns a new slice with the segment appended to the path leaving the path intact.
+func appendSegment(path []string, segment string) []string {
+	return append(path[:len(path):len(path)], segment)
 }
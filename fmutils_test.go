@@ -5,7 +5,13 @@ import (
 	"testing"
 
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
 	"github.com/mennanov/fmutils/testproto"
 )
@@ -47,6 +53,31 @@ func Test_NestedMaskFromPaths(t *testing.T) {
 			args: args{paths: []string{".", "..", "..."}},
 			want: NestedMask{},
 		},
+		{
+			name: "quoted segments",
+			args: args{paths: []string{"a.`b.c`.d", "a.`e``f`", "`g`"}},
+			want: NestedMask{
+				"a": NestedMask{"b.c": NestedMask{"d": NestedMask{}}, "e`f": NestedMask{}},
+				"g": NestedMask{}},
+		},
+		{
+			name: "malformed paths are taken literally",
+			args: args{paths: []string{"a.`b", "c`d", "`e`f", "g.h[i", "-g.h[i.j"}},
+			want: NestedMask{
+				"a":       NestedMask{"`b": NestedMask{}},
+				"c`d":     NestedMask{},
+				"`e`f":    NestedMask{},
+				"g":       NestedMask{"h[i": NestedMask{}},
+				exclusion: NestedMask{"g": NestedMask{"h[i": NestedMask{"j": NestedMask{}}}},
+			},
+		},
+		{
+			name: "whole field takes precedence over subfields",
+			args: args{paths: []string{"a.b.c", "a.b", "d", "d.e.f", "a.g"}},
+			want: NestedMask{
+				"a": NestedMask{"b": NestedMask{}, "g": NestedMask{}},
+				"d": NestedMask{}},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -57,6 +88,66 @@ func Test_NestedMaskFromPaths(t *testing.T) {
 	}
 }
 
+func TestNestedMask_Paths(t *testing.T) {
+	tests := []struct {
+		name string
+		mask NestedMask
+		want []string
+	}{
+		{
+			name: "empty mask",
+			mask: NestedMask{},
+			want: nil,
+		},
+		{
+			name: "no nested fields",
+			mask: NestedMask{"c": NestedMask{}, "a": NestedMask{}, "b": NestedMask{}},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "with nested fields",
+			mask: NestedMask{
+				"f":   NestedMask{},
+				"aaa": NestedMask{"bb": NestedMask{"c": NestedMask{}, "a": NestedMask{}}},
+				"dd":  NestedMask{"e": NestedMask{}}},
+			want: []string{"aaa.bb.a", "aaa.bb.c", "dd.e", "f"},
+		},
+		{
+			name: "with quoted segments",
+			mask: NestedMask{"a": NestedMask{"example.com": NestedMask{}, "b`c": NestedMask{}, "": NestedMask{}}},
+			want: []string{"a.``", "a.`b``c`", "a.`example.com`"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.mask.Paths()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Paths() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(NestedMaskFromPaths(got), tt.mask) {
+				t.Errorf("NestedMaskFromPaths(%v) = %v, want %v", got, NestedMaskFromPaths(got), tt.mask)
+			}
+		})
+	}
+}
+
+func TestNestedMask_FieldMask(t *testing.T) {
+	fm := &fieldmaskpb.FieldMask{Paths: []string{"user.name", "photo", "user", "gallery.path", "attributes.a1.tags"}}
+	want := &fieldmaskpb.FieldMask{Paths: []string{"attributes.a1.tags", "gallery.path", "photo", "user"}}
+	got, err := NestedMaskFromFieldMask(fm).FieldMask()
+	if err != nil {
+		t.Fatalf("FieldMask() error = %v, want nil", err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("FieldMask() = %v, want %v", got, want)
+	}
+
+	mask := NestedMaskFromPaths([]string{"user"}).Without(NestedMaskFromPaths([]string{"user.name"}))
+	if got, err := mask.FieldMask(); err != ErrExclusions {
+		t.Errorf("FieldMask() = %v, %v, want nil, %v", got, err, ErrExclusions)
+	}
+}
+
 func createAny(m proto.Message) *anypb.Any {
 	any, err := anypb.New(m)
 	if err != nil {
@@ -425,6 +516,39 @@ func TestFilter(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "mask with wildcard keeps all the fields",
+			paths: []string{"user.*", "photo.*"},
+			msg: &testproto.Profile{
+				User:            &testproto.User{UserId: 1, Name: "user name"},
+				Photo:           &testproto.Photo{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 100}},
+				LoginTimestamps: []int64{1, 2},
+			},
+			want: &testproto.Profile{
+				User:  &testproto.User{UserId: 1, Name: "user name"},
+				Photo: &testproto.Photo{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 100}},
+			},
+		},
+		{
+			name:  "mask with wildcard in map applies to all the entries",
+			paths: []string{"attributes.*.tags.t1", "attributes.a2.tags.t2", "gallery.*"},
+			msg: &testproto.Profile{
+				Gallery: []*testproto.Photo{{PhotoId: 1, Path: "path"}},
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1", "t2": "2", "t3": "3"}},
+					"a2": {Tags: map[string]string{"t1": "1", "t2": "2", "t3": "3"}},
+					"a3": {Tags: map[string]string{"t2": "2"}},
+				},
+			},
+			want: &testproto.Profile{
+				Gallery: []*testproto.Photo{{PhotoId: 1, Path: "path"}},
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1"}},
+					"a2": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+					"a3": {Tags: map[string]string{}},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -757,6 +881,36 @@ func TestPrune(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "mask with wildcard clears all the fields",
+			paths: []string{"user.*", "photo.dimensions.*"},
+			msg: &testproto.Profile{
+				User:            &testproto.User{UserId: 1, Name: "user name"},
+				Photo:           &testproto.Photo{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 100}},
+				LoginTimestamps: []int64{1, 2},
+			},
+			want: &testproto.Profile{
+				User:            &testproto.User{},
+				Photo:           &testproto.Photo{PhotoId: 2, Dimensions: &testproto.Dimensions{}},
+				LoginTimestamps: []int64{1, 2},
+			},
+		},
+		{
+			name:  "mask with wildcard in map applies to all the entries",
+			paths: []string{"attributes.*.tags.t1", "attributes.a2.tags.t2"},
+			msg: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1", "t2": "2", "t3": "3"}},
+					"a2": {Tags: map[string]string{"t1": "1", "t2": "2", "t3": "3"}},
+				},
+			},
+			want: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t2": "2", "t3": "3"}},
+					"a2": {Tags: map[string]string{"t3": "3"}},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -768,6 +922,350 @@ func TestPrune(t *testing.T) {
 	}
 }
 
+func TestFilter_mapKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  *testproto.Leaderboard
+	}{
+		{
+			name:  "int64 keys",
+			paths: []string{"scores.42.value", "scores.-1"},
+			want: &testproto.Leaderboard{
+				Scores: map[int64]*testproto.Score{42: {Value: 1}, -1: {Value: 2, Comment: "c"}},
+			},
+		},
+		{
+			name:  "integer keys with signs and leading zeros",
+			paths: []string{"scores.+042.comment", "int32_scores.-01", "sint32_scores.007"},
+			want: &testproto.Leaderboard{
+				Scores:       map[int64]*testproto.Score{42: {Comment: "c"}},
+				Int32Scores:  map[int32]*testproto.Score{-1: {Value: 1}},
+				Sint32Scores: map[int32]*testproto.Score{7: {Value: 1}},
+			},
+		},
+		{
+			name:  "same key in different forms",
+			paths: []string{"scores.42.value", "scores.042.comment"},
+			want: &testproto.Leaderboard{
+				Scores: map[int64]*testproto.Score{42: {Value: 1, Comment: "c"}},
+			},
+		},
+		{
+			name:  "fixed and unsigned keys",
+			paths: []string{"sfixed64_scores.-5", "uint32_scores.4294967295", "uint64_scores.18446744073709551615", "fixed32_scores.3"},
+			want: &testproto.Leaderboard{
+				Sfixed64Scores: map[int64]*testproto.Score{-5: {Value: 1}},
+				Uint32Scores:   map[uint32]*testproto.Score{4294967295: {Value: 1}},
+				Uint64Scores:   map[uint64]*testproto.Score{18446744073709551615: {Value: 1}},
+				Fixed32Scores:  map[uint32]*testproto.Score{3: {Value: 1}},
+			},
+		},
+		{
+			name:  "bool keys",
+			paths: []string{"bool_scores.true.value"},
+			want: &testproto.Leaderboard{
+				BoolScores: map[bool]*testproto.Score{true: {Value: 1}},
+			},
+		},
+		{
+			name:  "wildcard with integer keys",
+			paths: []string{"scores.*.value", "scores.42.comment", "labels.*"},
+			want: &testproto.Leaderboard{
+				Scores: map[int64]*testproto.Score{42: {Value: 1, Comment: "c"}, -1: {Value: 2}},
+				Labels: map[int64]string{1: "one", 2: "two"},
+			},
+		},
+		{
+			name:  "keys that can not be parsed are ignored",
+			paths: []string{"scores.abc", "uint32_scores.-1", "bool_scores.yes", "labels.1"},
+			want: &testproto.Leaderboard{
+				Labels: map[int64]string{1: "one"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newLeaderboard()
+			Filter(msg, tt.paths)
+			if !proto.Equal(msg, tt.want) {
+				t.Errorf("msg %v, want %v", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrune_mapKeys(t *testing.T) {
+	msg := newLeaderboard()
+	Prune(msg, []string{"scores.+42.value", "scores.-01", "uint64_scores.18446744073709551615", "bool_scores.false",
+		"labels.02"})
+	want := newLeaderboard()
+	want.Scores = map[int64]*testproto.Score{42: {Comment: "c"}}
+	want.Uint64Scores = map[uint64]*testproto.Score{}
+	want.BoolScores = map[bool]*testproto.Score{true: {Value: 1}}
+	want.Labels = map[int64]string{1: "one"}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
+
+// newLeaderboard returns a message that has two entries in every map.
+func newLeaderboard() *testproto.Leaderboard {
+	return &testproto.Leaderboard{
+		Scores:         map[int64]*testproto.Score{42: {Value: 1, Comment: "c"}, -1: {Value: 2, Comment: "c"}},
+		Int32Scores:    map[int32]*testproto.Score{-1: {Value: 1}, 1: {Value: 2}},
+		Sint32Scores:   map[int32]*testproto.Score{7: {Value: 1}, -7: {Value: 2}},
+		Sfixed64Scores: map[int64]*testproto.Score{-5: {Value: 1}, 5: {Value: 2}},
+		Uint32Scores:   map[uint32]*testproto.Score{4294967295: {Value: 1}, 1: {Value: 2}},
+		Uint64Scores:   map[uint64]*testproto.Score{18446744073709551615: {Value: 1}},
+		Fixed32Scores:  map[uint32]*testproto.Score{3: {Value: 1}, 4: {Value: 2}},
+		BoolScores:     map[bool]*testproto.Score{true: {Value: 1}, false: {Value: 2}},
+		Labels:         map[int64]string{1: "one", 2: "two"},
+	}
+}
+
+// olderProfileType returns the type of testproto.Profile built from the descriptors without the given fields, so that
+// the messages marshaled with the current descriptors have unknown fields when unmarshaled into it.
+func olderProfileType(t *testing.T, removed ...protoreflect.FullName) protoreflect.MessageType {
+	fdp := protodesc.ToFileDescriptorProto(testproto.File_testproto_proto)
+	for _, mdp := range fdp.GetMessageType() {
+		var fields []*descriptorpb.FieldDescriptorProto
+		for _, f := range mdp.GetField() {
+			name := protoreflect.FullName(fdp.GetPackage()).Append(protoreflect.Name(mdp.GetName())).
+				Append(protoreflect.Name(f.GetName()))
+			keep := true
+			for _, r := range removed {
+				keep = keep && r != name
+			}
+			if keep {
+				fields = append(fields, f)
+			}
+		}
+		mdp.Field = fields
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("protodesc.NewFile() error = %v", err)
+	}
+	return dynamicpb.NewMessageType(fd.Messages().ByName("Profile"))
+}
+
+// roundTrip marshals the message and unmarshals it into a new message of the given type.
+func roundTrip(t *testing.T, msg proto.Message, mt protoreflect.MessageType) proto.Message {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+	result := mt.New().Interface()
+	if err := proto.Unmarshal(b, result); err != nil {
+		t.Fatalf("proto.Unmarshal() error = %v", err)
+	}
+	return result
+}
+
+func TestFilter_unknownFields(t *testing.T) {
+	older := olderProfileType(t, "testproto.Profile.attributes", "testproto.User.name", "testproto.Photo.dimensions")
+	current := (&testproto.Profile{}).ProtoReflect().Type()
+	msg := &testproto.Profile{
+		User:  &testproto.User{UserId: 1, Name: "name"},
+		Photo: &testproto.Photo{PhotoId: 1, Path: "path", Dimensions: &testproto.Dimensions{Width: 1}},
+		Gallery: []*testproto.Photo{
+			{PhotoId: 2, Path: "path 2", Dimensions: &testproto.Dimensions{Width: 2}},
+		},
+		Attributes: map[string]*testproto.Attribute{"a1": {Tags: map[string]string{"t1": "1"}}},
+	}
+	mask := NestedMaskFromPaths([]string{"user", "photo.path", "gallery.photo_id"})
+	md := older.Descriptor()
+
+	tests := []struct {
+		name  string
+		apply func(proto.Message) proto.Message
+		want  proto.Message
+	}{
+		{
+			name: "Filter clears unknown fields",
+			apply: func(m proto.Message) proto.Message {
+				mask.Filter(m)
+				return m
+			},
+			want: &testproto.Profile{
+				User:    &testproto.User{UserId: 1, Name: "name"},
+				Photo:   &testproto.Photo{Path: "path"},
+				Gallery: []*testproto.Photo{{PhotoId: 2}},
+			},
+		},
+		{
+			name: "FilterCopy does not copy unknown fields",
+			apply: func(m proto.Message) proto.Message {
+				return mask.FilterCopy(m)
+			},
+			want: &testproto.Profile{
+				User:    &testproto.User{UserId: 1, Name: "name"},
+				Photo:   &testproto.Photo{Path: "path"},
+				Gallery: []*testproto.Photo{{PhotoId: 2}},
+			},
+		},
+		{
+			name: "CompiledMask.Filter clears unknown fields",
+			apply: func(m proto.Message) proto.Message {
+				mask.Compile(md).Filter(m)
+				return m
+			},
+			want: &testproto.Profile{
+				User:    &testproto.User{UserId: 1, Name: "name"},
+				Photo:   &testproto.Photo{Path: "path"},
+				Gallery: []*testproto.Photo{{PhotoId: 2}},
+			},
+		},
+		{
+			name: "Filter keeps unknown fields with the option",
+			apply: func(m proto.Message) proto.Message {
+				mask.Filter(m, WithUnknownFields())
+				return m
+			},
+			want: &testproto.Profile{
+				User:       &testproto.User{UserId: 1, Name: "name"},
+				Photo:      &testproto.Photo{Path: "path", Dimensions: &testproto.Dimensions{Width: 1}},
+				Gallery:    []*testproto.Photo{{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 2}}},
+				Attributes: msg.Attributes,
+			},
+		},
+		{
+			name: "FilterCopy copies unknown fields with the option",
+			apply: func(m proto.Message) proto.Message {
+				return mask.FilterCopy(m, WithUnknownFields())
+			},
+			want: &testproto.Profile{
+				User:       &testproto.User{UserId: 1, Name: "name"},
+				Photo:      &testproto.Photo{Path: "path", Dimensions: &testproto.Dimensions{Width: 1}},
+				Gallery:    []*testproto.Photo{{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 2}}},
+				Attributes: msg.Attributes,
+			},
+		},
+		{
+			name: "Prune keeps unknown fields",
+			apply: func(m proto.Message) proto.Message {
+				NestedMaskFromPaths([]string{"user.user_id", "photo.path", "gallery.path"}).Prune(m)
+				return m
+			},
+			want: &testproto.Profile{
+				User:       &testproto.User{Name: "name"},
+				Photo:      &testproto.Photo{PhotoId: 1, Dimensions: &testproto.Dimensions{Width: 1}},
+				Gallery:    []*testproto.Photo{{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 2}}},
+				Attributes: msg.Attributes,
+			},
+		},
+		{
+			name: "PruneCopy copies unknown fields",
+			apply: func(m proto.Message) proto.Message {
+				return NestedMaskFromPaths([]string{"user.user_id", "photo.path", "gallery.path"}).PruneCopy(m)
+			},
+			want: &testproto.Profile{
+				User:       &testproto.User{Name: "name"},
+				Photo:      &testproto.Photo{PhotoId: 1, Dimensions: &testproto.Dimensions{Width: 1}},
+				Gallery:    []*testproto.Photo{{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 2}}},
+				Attributes: msg.Attributes,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, tt.apply(roundTrip(t, msg, older)), current)
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithClearEmpty(t *testing.T) {
+	newProfile := func() *testproto.Profile {
+		return &testproto.Profile{
+			User:  &testproto.User{},
+			Photo: &testproto.Photo{Path: "path", Dimensions: &testproto.Dimensions{Width: 1}},
+			Gallery: []*testproto.Photo{
+				{Path: "path 1"},
+				{},
+				{PhotoId: 3, Path: "path 3"},
+			},
+			Attributes: map[string]*testproto.Attribute{
+				"a1": {Tags: map[string]string{"t1": "1"}},
+				"a2": {},
+			},
+		}
+	}
+	tests := []struct {
+		name  string
+		paths []string
+		prune bool
+		want  proto.Message
+	}{
+		{
+			name:  "Prune clears emptied messages bottom-up",
+			paths: []string{"photo.path", "photo.dimensions.width"},
+			prune: true,
+			want: &testproto.Profile{
+				User:       &testproto.User{},
+				Gallery:    newProfile().Gallery,
+				Attributes: newProfile().Attributes,
+			},
+		},
+		{
+			name:  "Prune clears emptied list elements and map entries",
+			paths: []string{"gallery.path", "attributes.*.tags"},
+			prune: true,
+			want: &testproto.Profile{
+				User:       &testproto.User{},
+				Photo:      newProfile().Photo,
+				Gallery:    []*testproto.Photo{{}, {PhotoId: 3}},
+				Attributes: map[string]*testproto.Attribute{"a2": {}},
+			},
+		},
+		{
+			name:  "Filter clears emptied messages",
+			paths: []string{"user", "photo.dimensions.height", "gallery.photo_id", "attributes.a2", "attributes.a1.tags.t2"},
+			want: &testproto.Profile{
+				User:       &testproto.User{},
+				Gallery:    []*testproto.Photo{{}, {PhotoId: 3}},
+				Attributes: map[string]*testproto.Attribute{"a2": {}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask := NestedMaskFromPaths(tt.paths)
+			compiled := mask.Compile((&testproto.Profile{}).ProtoReflect().Descriptor())
+			apply, applyCopy, applyCompiled := mask.Filter, mask.FilterCopy, compiled.Filter
+			if tt.prune {
+				apply, applyCopy, applyCompiled = mask.Prune, mask.PruneCopy, compiled.Prune
+			}
+
+			got := newProfile()
+			apply(got, WithClearEmpty())
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if got.User == nil {
+				t.Errorf("the message that was empty to begin with is cleared")
+			}
+			if got := applyCopy(newProfile(), WithClearEmpty()); !proto.Equal(got, tt.want) {
+				t.Errorf("copy %v, want %v", got, tt.want)
+			}
+			got = newProfile()
+			applyCompiled(got, WithClearEmpty())
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("compiled %v, want %v", got, tt.want)
+			}
+
+			got = newProfile()
+			apply(got)
+			if proto.Equal(got, tt.want) {
+				t.Errorf("got %v without the option, want the emptied messages kept", got)
+			}
+		})
+	}
+}
+
 func BenchmarkNestedMaskFromPaths(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		NestedMaskFromPaths([]string{"aaa.bbb.c.d.e.f", "aa.b.cc.ddddddd", "e", "f", "g.h.i.j.k"})
@@ -4,5 +4,5 @@ go 1.15
 
 require (
 	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
-	google.golang.org/protobuf v1.26.0
+	google.golang.org/protobuf v1.30.0
 )
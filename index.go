@@ -0,0 +1,339 @@
+package fmutils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// listMask is the mask of a repeated field split into the mask of all the elements and the masks of the elements
+// addressed by their indices or selected by the values of their key fields.
+type listMask struct {
+	// all is the mask of every element, nil if the mask lists only some of the elements.
+	all NestedMask
+	// indices holds the masks of the elements by their indices as given in the mask, a negative index counts from the
+	// end of the list.
+	indices map[int]NestedMask
+	// selectors holds the masks of the elements selected by the values of their fields.
+	selectors []selector
+	// excludes is the mask of the excluded elements and subfields, nil if the mask has no exclusions that address the
+	// elements.
+	excludes *listMask
+}
+
+// selector is the mask of the list elements which field has the given value.
+type selector struct {
+	// fd is the field of the element, nil if the selector does not match the element type.
+	fd    protoreflect.FieldDescriptor
+	value protoreflect.Value
+	mask  NestedMask
+}
+
+// listMask splits the mask of the repeated field, the segments that are integers are the indices of the elements and
+// the segments like "[photo_id=2]" are the selectors of the elements.
+//
+// The field names can not start with a digit, a minus sign or '[', so the indices and selectors never clash with the
+// subfields of the elements.
+//
+// The exclusions that do not address the elements are kept in the mask of every element, the ones that do are split
+// the same way.
+func (mask NestedMask) listMask(fd protoreflect.FieldDescriptor) listMask {
+	l := listMask{all: mask}
+	if mask == nil {
+		l.all = NestedMask{}
+	}
+	includes, excludes := mask.split()
+	if !includes.addressesElements() && !excludes.addressesElements() {
+		return l
+	}
+	l.all, l.indices = make(NestedMask), make(map[int]NestedMask)
+	if excludes != nil {
+		x := excludes.listMask(fd)
+		l.excludes = &x
+	}
+	for _, key := range includes.sortedKeys() {
+		m := includes[key]
+		if i, ok := parseIndex(key); ok {
+			l.indices[i] = l.indices[i].unionElement(m)
+		} else if field, value, ok := splitSelector(key); ok {
+			s := selector{mask: m}
+			s.fd, s.value, _ = resolveSelector(fd, field, value)
+			l.selectors = append(l.selectors, s)
+		} else {
+			l.all[key] = m
+		}
+	}
+	if len(l.all) == 0 {
+		l.all = nil
+	}
+	return l
+}
+
+// addressesElements reports whether the mask of a repeated field addresses the elements by their indices or selectors.
+func (mask NestedMask) addressesElements() bool {
+	for key := range mask {
+		if _, ok := parseIndex(key); ok {
+			return true
+		}
+		if _, _, ok := splitSelector(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// indexed reports whether the mask or its exclusions address the elements by their indices or selectors.
+func (l listMask) indexed() bool {
+	return l.indices != nil
+}
+
+// element returns the mask of the i-th element v of a list of n elements, ok is false if the element is not listed.
+func (l listMask) element(i, n int, v protoreflect.Value) (NestedMask, bool) {
+	m, ok := l.position(i, n)
+	for _, s := range l.selectors {
+		if s.matches(v) {
+			m = m.unionElement(s.mask)
+			ok = true
+		}
+	}
+	return l.excluded(i, n, v, m, ok)
+}
+
+// excluded applies the exclusions to the mask m of the i-th element v of a list of n elements.
+func (l listMask) excluded(i, n int, v protoreflect.Value, m NestedMask, ok bool) (NestedMask, bool) {
+	if !ok || l.excludes == nil {
+		return m, ok
+	}
+	xm, excluded := l.excludes.element(i, n, v)
+	switch {
+	case !excluded:
+		return m, true
+	case len(xm) == 0:
+		return nil, false
+	default:
+		return m.exclude(xm), true
+	}
+}
+
+// position returns the mask of the i-th element of a list of n elements not taking the selectors into account.
+func (l listMask) position(i, n int) (NestedMask, bool) {
+	m, ok := l.all, l.all != nil
+	for _, index := range [2]int{i, i - n} {
+		if im, found := l.indices[index]; found {
+			m = m.unionElement(im)
+			ok = true
+		}
+	}
+	return m, ok
+}
+
+// matches reports whether the list element has the selected field value.
+func (s selector) matches(v protoreflect.Value) bool {
+	if s.fd == nil {
+		return false
+	}
+	m, ok := v.Interface().(protoreflect.Message)
+	return ok && m.Descriptor() == s.fd.ContainingMessage() && m.Get(s.fd).Equal(s.value)
+}
+
+// resolveSelector resolves the selector field of the elements of the repeated field and parses the value according to
+// the field kind.
+func resolveSelector(fd protoreflect.FieldDescriptor, field, value string) (protoreflect.FieldDescriptor,
+	protoreflect.Value, error) {
+	if fd.Message() == nil || fd.IsMap() {
+		return nil, protoreflect.Value{}, fmt.Errorf("%s elements are not messages", fd.FullName())
+	}
+	sfd := fieldByName(fd.Message(), field)
+	if sfd == nil || sfd.Cardinality() == protoreflect.Repeated || sfd.Message() != nil {
+		return nil, protoreflect.Value{}, fmt.Errorf("%q is not a singular scalar field of %s", field,
+			fd.Message().FullName())
+	}
+	v, err := parseScalar(sfd, value)
+	if err != nil {
+		return nil, protoreflect.Value{}, err
+	}
+	return sfd, v, nil
+}
+
+// parseScalar parses the value of the scalar field.
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+		}
+	case protoreflect.FloatKind:
+		if f, err := strconv.ParseFloat(s, 32); err == nil {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+	case protoreflect.DoubleKind:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(s)), nil
+	default:
+		if mk, err := parseMapKey(fd, s); err == nil {
+			return mk.Value(), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("%q is not a valid %s value", s, fd.Kind())
+}
+
+// checkRange adds an error for every index that is out of range of a list of n elements, path is the path of the
+// list.
+func (l listMask) checkRange(n int, path []string, errs *[]*PathError) {
+	if l.excludes != nil {
+		validateExcluded(errs, func(errs *[]*PathError) {
+			l.excludes.checkRange(n, path, errs)
+		})
+	}
+	var invalid []int
+	for i := range l.indices {
+		if i >= n || i < -n {
+			invalid = append(invalid, i)
+		}
+	}
+	sort.Ints(invalid)
+	for _, i := range invalid {
+		segment := strconv.Itoa(i)
+		*errs = append(*errs, &PathError{Path: joinPath(appendSegment(path, segment)), Segment: segment,
+			Err: ErrInvalidIndex})
+	}
+}
+
+// checkIndices adds an error for every index of the mask that is out of range of the list it addresses in the
+// message, path is the path of the message. The message is not modified.
+//
+// The messages packed into the google.protobuf.Any fields that can not be unpacked are skipped.
+func (mask NestedMask) checkIndices(m protoreflect.Message, path []string, o *options, errs *[]*PathError) {
+	if len(mask) == 0 {
+		return
+	}
+	if o.resolvesAny(m.Descriptor()) {
+		if packed, err := o.unpackAny(m); err == nil {
+			o.protoNames(mask, packed.Descriptor()).checkIndices(packed, path, o, errs)
+		}
+		return
+	}
+	for _, fd := range populatedFields(m) {
+		fm, ok := mask.child(string(fd.Name()))
+		if !ok || len(fm) == 0 {
+			continue
+		}
+		fieldPath := appendSegment(path, string(fd.Name()))
+		switch {
+		case fd.IsList():
+			list, l := m.Get(fd).List(), fm.listMask(fd)
+			l.checkRange(list.Len(), fieldPath, errs)
+			if fd.Message() == nil {
+				continue
+			}
+			for i := 0; i < list.Len(); i++ {
+				if em, ok := l.element(i, list.Len(), list.Get(i)); ok {
+					em.checkIndices(list.Get(i).Message(), appendSegment(fieldPath, strconv.Itoa(i)), o, errs)
+				}
+			}
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				continue
+			}
+			fm = fm.withMapKeys(fd.MapKey())
+			xmap := m.Get(fd).Map()
+			for _, mk := range sortedMapKeys(xmap) {
+				if em, ok := fm.child(mk.String()); ok {
+					em.checkIndices(xmap.Get(mk).Message(), appendSegment(fieldPath, mk.String()), o, errs)
+				}
+			}
+		case fd.Message() != nil:
+			fm.checkIndices(m.Get(fd).Message(), fieldPath, o, errs)
+		}
+	}
+}
+
+// unionElement returns the union of the masks of a list element, nil stands for the element that is not listed.
+func (mask NestedMask) unionElement(other NestedMask) NestedMask {
+	switch {
+	case mask == nil:
+		return other
+	case len(mask) == 0 || len(other) == 0:
+		return NestedMask{}
+	default:
+		return mask.Union(other)
+	}
+}
+
+// parseIndex parses the path segment as an index of a list element.
+func parseIndex(s string) (int, bool) {
+	i, err := strconv.Atoi(s)
+	return i, err == nil
+}
+
+// resolveIndex returns the index of the element of a list of n elements, a negative index counts from the end of the
+// list.
+func resolveIndex(i, n int) (int, bool) {
+	if i < 0 {
+		i += n
+	}
+	return i, i >= 0 && i < n
+}
+
+// filterList filters the elements of the list with the mask of the repeated field.
+func (mask NestedMask) filterList(fd protoreflect.FieldDescriptor, list protoreflect.List, o *options) error {
+	return mask.listMask(fd).apply(list, func(m NestedMask, v protoreflect.Value) (bool, error) {
+		if msg, ok := v.Interface().(protoreflect.Message); ok && len(m) != 0 {
+			var ferr error
+			return !o.apply(msg, m.filterMessage, &ferr), ferr
+		}
+		return true, nil
+	}, false, o)
+}
+
+// pruneList prunes the elements of the list with the mask of the repeated field.
+func (mask NestedMask) pruneList(fd protoreflect.FieldDescriptor, list protoreflect.List, o *options) error {
+	return mask.listMask(fd).apply(list, func(m NestedMask, v protoreflect.Value) (bool, error) {
+		if msg, ok := v.Interface().(protoreflect.Message); ok && len(m) != 0 {
+			var ferr error
+			return !o.apply(msg, m.pruneMessage, &ferr), ferr
+		}
+		return false, nil
+	}, true, o)
+}
+
+// apply calls fn for the listed elements of the list and removes the elements for which fn returns false. The
+// elements that are not listed are kept if keepUnlisted is set and removed otherwise.
+func (l listMask) apply(list protoreflect.List, fn func(NestedMask, protoreflect.Value) (bool, error),
+	keepUnlisted bool, o *options) error {
+	n := list.Len()
+	kept := make([]protoreflect.Value, 0, n)
+	var err error
+	for i := 0; i < n; i++ {
+		v := list.Get(i)
+		m, ok := l.element(i, n, v)
+		keep := keepUnlisted
+		if ok {
+			var ferr error
+			o.enter(strconv.Itoa(i))
+			keep, ferr = fn(m, v)
+			o.leave(1)
+			err = firstError(err, ferr)
+		}
+		if keep {
+			kept = append(kept, v)
+		} else {
+			o.removedElement(i)
+		}
+	}
+	if len(kept) != n {
+		list.Truncate(0)
+		for _, v := range kept {
+			list.Append(v)
+		}
+	}
+	return err
+}
@@ -0,0 +1,451 @@
+package fmutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func newGalleryProfile() *testproto.Profile {
+	return &testproto.Profile{
+		LoginTimestamps: []int64{1, 2, 3},
+		Gallery: []*testproto.Photo{
+			{PhotoId: 1, Path: "path 1", Dimensions: &testproto.Dimensions{Width: 1, Height: 1}},
+			{PhotoId: 2, Path: "path 2", Dimensions: &testproto.Dimensions{Width: 2, Height: 2}},
+			{PhotoId: 3, Path: "path 3", Dimensions: &testproto.Dimensions{Width: 3, Height: 3}},
+		},
+	}
+}
+
+func TestIndexPaths(t *testing.T) {
+	tests := []struct {
+		name       string
+		paths      []string
+		wantFilter proto.Message
+		wantPrune  proto.Message
+	}{
+		{
+			name:  "element as a whole",
+			paths: []string{"gallery.1", "login_timestamps.0"},
+			wantFilter: &testproto.Profile{
+				LoginTimestamps: []int64{1},
+				Gallery:         []*testproto.Photo{newGalleryProfile().Gallery[1]},
+			},
+			wantPrune: &testproto.Profile{
+				LoginTimestamps: []int64{2, 3},
+				Gallery:         []*testproto.Photo{newGalleryProfile().Gallery[0], newGalleryProfile().Gallery[2]},
+			},
+		},
+		{
+			name:       "negative index",
+			paths:      []string{"gallery.-1.path", "login_timestamps.-2"},
+			wantFilter: &testproto.Profile{LoginTimestamps: []int64{2}, Gallery: []*testproto.Photo{{Path: "path 3"}}},
+			wantPrune: &testproto.Profile{
+				LoginTimestamps: []int64{1, 3},
+				Gallery: []*testproto.Photo{
+					{PhotoId: 1, Path: "path 1", Dimensions: &testproto.Dimensions{Width: 1, Height: 1}},
+					{PhotoId: 2, Path: "path 2", Dimensions: &testproto.Dimensions{Width: 2, Height: 2}},
+					{PhotoId: 3, Dimensions: &testproto.Dimensions{Width: 3, Height: 3}},
+				},
+			},
+		},
+		{
+			name:  "indices along with the subfields of all the elements",
+			paths: []string{"gallery.photo_id", "gallery.0.path", "gallery.-3.dimensions.width"},
+			wantFilter: &testproto.Profile{Gallery: []*testproto.Photo{
+				{PhotoId: 1, Path: "path 1", Dimensions: &testproto.Dimensions{Width: 1}},
+				{PhotoId: 2},
+				{PhotoId: 3},
+			}},
+			wantPrune: &testproto.Profile{
+				LoginTimestamps: []int64{1, 2, 3},
+				Gallery: []*testproto.Photo{
+					{Dimensions: &testproto.Dimensions{Height: 1}},
+					{Path: "path 2", Dimensions: &testproto.Dimensions{Width: 2, Height: 2}},
+					{Path: "path 3", Dimensions: &testproto.Dimensions{Width: 3, Height: 3}},
+				},
+			},
+		},
+		{
+			name:       "indices out of range are ignored",
+			paths:      []string{"gallery.3", "gallery.-4", "login_timestamps.5"},
+			wantFilter: &testproto.Profile{},
+			wantPrune:  newGalleryProfile(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask := NestedMaskFromPaths(tt.paths)
+			md := (&testproto.Profile{}).ProtoReflect().Descriptor()
+
+			got := newGalleryProfile()
+			mask.Filter(got)
+			if !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("Filter() = %v, want %v", got, tt.wantFilter)
+			}
+			if got := mask.FilterCopy(newGalleryProfile()); !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("FilterCopy() = %v, want %v", got, tt.wantFilter)
+			}
+			got = newGalleryProfile()
+			mask.Compile(md).Filter(got)
+			if !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("CompiledMask.Filter() = %v, want %v", got, tt.wantFilter)
+			}
+
+			got = newGalleryProfile()
+			mask.Prune(got)
+			if !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("Prune() = %v, want %v", got, tt.wantPrune)
+			}
+			if got := mask.PruneCopy(newGalleryProfile()); !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("PruneCopy() = %v, want %v", got, tt.wantPrune)
+			}
+			got = newGalleryProfile()
+			mask.Compile(md).Prune(got)
+			if !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("CompiledMask.Prune() = %v, want %v", got, tt.wantPrune)
+			}
+
+			if err := mask.Validate(md); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestIndexPaths_checked(t *testing.T) {
+	paths := []string{"gallery.0.path", "gallery.3", "gallery.-1.path", "-gallery.-4", "login_timestamps"}
+	wantErr := &ValidationError{Errors: []*PathError{
+		{Path: "-gallery.-4", Segment: "-4", Err: ErrInvalidIndex},
+		{Path: "gallery.3", Segment: "3", Err: ErrInvalidIndex},
+	}}
+	for name, checked := range map[string]func(proto.Message, []string, ...Option) error{
+		"FilterChecked": FilterChecked,
+		"PruneChecked":  PruneChecked,
+	} {
+		msg := newGalleryProfile()
+		err := checked(msg, paths)
+		var verr *ValidationError
+		if !errors.As(err, &verr) || !reflect.DeepEqual(verr, wantErr) {
+			t.Errorf("%s() error = %v, want %v", name, err, wantErr)
+		}
+		if !errors.Is(err, ErrInvalidIndex) {
+			t.Errorf("%s() error = %v, want %v", name, err, ErrInvalidIndex)
+		}
+		if !proto.Equal(msg, newGalleryProfile()) {
+			t.Errorf("%s() modified the message: %v", name, msg)
+		}
+	}
+	if err := PruneChecked(newGalleryProfile(), []string{"gallery.0.path", "gallery.-3"}); err != nil {
+		t.Errorf("PruneChecked() error = %v, want nil", err)
+	}
+
+	md := (&testproto.Profile{}).ProtoReflect().Descriptor()
+	err := NestedMaskFromPaths([]string{"gallery.0.unknown", "login_timestamps.0.a", "user.0"}).Validate(md)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want a *ValidationError", err)
+	}
+	var got []string
+	for _, pe := range verr.Errors {
+		got = append(got, pe.Path+": "+pe.Err.Error())
+	}
+	want := []string{
+		"gallery.0.unknown: " + ErrUnknownField.Error(),
+		"login_timestamps.0.a: " + ErrScalarDescent.Error(),
+		"user.0: " + ErrUnknownField.Error(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() errors = %q, want %q", got, want)
+	}
+}
+
+func TestIndexPaths_names(t *testing.T) {
+	md := (&testproto.Profile{}).ProtoReflect().Descriptor()
+	mask := NestedMaskFromPaths([]string{"gallery.photoId", "gallery.-1.dimensions.width", "gallery.0"})
+	want := []string{"gallery.-1.dimensions.width", "gallery.0", "gallery.photo_id"}
+	if got := mask.ProtoNames(md).Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProtoNames() = %v, want %v", got, want)
+	}
+}
+
+func TestIndexPaths_Overwrite(t *testing.T) {
+	src := &testproto.Profile{
+		LoginTimestamps: []int64{10, 20},
+		Gallery:         []*testproto.Photo{{PhotoId: 10, Path: "new 1"}, {PhotoId: 20, Path: "new 2"}},
+	}
+	dst := newGalleryProfile()
+	NestedMaskFromPaths([]string{"gallery.0.path", "gallery.1", "login_timestamps.-3"}).Overwrite(dst, src)
+	want := newGalleryProfile()
+	want.LoginTimestamps[0] = 10
+	want.Gallery[0].Path = "new 1"
+	want.Gallery[1] = &testproto.Photo{PhotoId: 20, Path: "new 2"}
+	if !proto.Equal(dst, want) {
+		t.Errorf("Overwrite() = %v, want %v", dst, want)
+	}
+}
+
+func TestIndexPaths_Walk(t *testing.T) {
+	var got []string
+	err := NestedMaskFromPaths([]string{"gallery.-1.path", "login_timestamps.1"}).Walk(newGalleryProfile(),
+		func(path Path, _ protoreflect.FieldDescriptor, _ protoreflect.Value) error {
+			got = append(got, path.String())
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	if want := []string{"login_timestamps.1", "gallery.2.path"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() visited %q, want %q", got, want)
+	}
+}
+
+func TestParseNestedMask_selectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		want    NestedMask
+		wantErr *SyntaxError
+	}{
+		{
+			name:  "selectors",
+			paths: []string{"gallery[photo_id=2].path", "gallery[path=`a.b[1]`]", "attributes.`a[0]`", "gallery.[path=]"},
+			want: NestedMask{
+				"gallery": NestedMask{
+					"[photo_id=2]":  NestedMask{"path": NestedMask{}},
+					"[path=a.b[1]]": NestedMask{},
+					"[path=]":       NestedMask{},
+				},
+				"attributes": NestedMask{"a[0]": NestedMask{}},
+			},
+		},
+		{
+			name:    "missing value",
+			paths:   []string{"gallery[photo_id]"},
+			wantErr: &SyntaxError{Path: "gallery[photo_id]", Offset: 16, Msg: "expected '=' in a selector"},
+		},
+		{
+			name:    "empty field",
+			paths:   []string{"gallery[=1]"},
+			wantErr: &SyntaxError{Path: "gallery[=1]", Offset: 8, Msg: "empty selector field"},
+		},
+		{
+			name:    "unterminated selector",
+			paths:   []string{"gallery[photo_id=1"},
+			wantErr: &SyntaxError{Path: "gallery[photo_id=1", Offset: 18, Msg: "expected ']' at the end of a selector"},
+		},
+		{
+			name:    "characters after a selector",
+			paths:   []string{"gallery[photo_id=1]path"},
+			wantErr: &SyntaxError{Path: "gallery[photo_id=1]path", Offset: 19, Msg: "expected '.' after a selector"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNestedMask(tt.paths)
+			if tt.wantErr != nil {
+				var serr *SyntaxError
+				if !errors.As(err, &serr) || !reflect.DeepEqual(serr, tt.wantErr) {
+					t.Errorf("ParseNestedMask() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseNestedMask() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseNestedMask() = %v, want %v", got, tt.want)
+			}
+			if back := NestedMaskFromPaths(got.Paths()); !reflect.DeepEqual(back, got) {
+				t.Errorf("NestedMaskFromPaths(Paths()) = %v, want %v", back, got)
+			}
+		})
+	}
+}
+
+func TestSelectorPaths(t *testing.T) {
+	tests := []struct {
+		name       string
+		paths      []string
+		wantFilter proto.Message
+		wantPrune  proto.Message
+	}{
+		{
+			name:       "element as a whole",
+			paths:      []string{"gallery[photo_id=2]"},
+			wantFilter: &testproto.Profile{Gallery: []*testproto.Photo{newGalleryProfile().Gallery[1]}},
+			wantPrune: &testproto.Profile{
+				LoginTimestamps: []int64{1, 2, 3},
+				Gallery:         []*testproto.Photo{newGalleryProfile().Gallery[0], newGalleryProfile().Gallery[2]},
+			},
+		},
+		{
+			name:  "subfields of the selected elements",
+			paths: []string{"gallery[path=path 3].dimensions.width", "gallery[photo_id=3].photo_id", "gallery.0.path"},
+			wantFilter: &testproto.Profile{Gallery: []*testproto.Photo{
+				{Path: "path 1"},
+				{PhotoId: 3, Dimensions: &testproto.Dimensions{Width: 3}},
+			}},
+			wantPrune: &testproto.Profile{
+				LoginTimestamps: []int64{1, 2, 3},
+				Gallery: []*testproto.Photo{
+					{PhotoId: 1, Dimensions: &testproto.Dimensions{Width: 1, Height: 1}},
+					newGalleryProfile().Gallery[1],
+					{Path: "path 3", Dimensions: &testproto.Dimensions{Height: 3}},
+				},
+			},
+		},
+		{
+			name:       "invalid selectors match nothing",
+			paths:      []string{"gallery[photo_id=x]", "gallery[unknown=1]", "gallery[dimensions=1]", "login_timestamps[a=1]"},
+			wantFilter: &testproto.Profile{},
+			wantPrune:  newGalleryProfile(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask := NestedMaskFromPaths(tt.paths)
+			md := (&testproto.Profile{}).ProtoReflect().Descriptor()
+
+			got := newGalleryProfile()
+			mask.Filter(got)
+			if !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("Filter() = %v, want %v", got, tt.wantFilter)
+			}
+			if got := mask.FilterCopy(newGalleryProfile()); !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("FilterCopy() = %v, want %v", got, tt.wantFilter)
+			}
+			got = newGalleryProfile()
+			mask.Compile(md).Filter(got)
+			if !proto.Equal(got, tt.wantFilter) {
+				t.Errorf("CompiledMask.Filter() = %v, want %v", got, tt.wantFilter)
+			}
+
+			got = newGalleryProfile()
+			mask.Prune(got)
+			if !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("Prune() = %v, want %v", got, tt.wantPrune)
+			}
+			if got := mask.PruneCopy(newGalleryProfile()); !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("PruneCopy() = %v, want %v", got, tt.wantPrune)
+			}
+			got = newGalleryProfile()
+			mask.Compile(md).Prune(got)
+			if !proto.Equal(got, tt.wantPrune) {
+				t.Errorf("CompiledMask.Prune() = %v, want %v", got, tt.wantPrune)
+			}
+		})
+	}
+}
+
+func TestSelectorPaths_Validate(t *testing.T) {
+	md := (&testproto.Profile{}).ProtoReflect().Descriptor()
+	mask := NestedMaskFromPaths([]string{
+		"gallery[photo_id=2].path",
+		"gallery[path=x].unknown",
+		"gallery[photo_id=x]",
+		"gallery[unknown=1]",
+		"gallery[dimensions=1]",
+		"login_timestamps[a=1]",
+	})
+	err := mask.Validate(md)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want a *ValidationError", err)
+	}
+	var got []string
+	for _, pe := range verr.Errors {
+		got = append(got, pe.Path+": "+pe.Err.Error())
+	}
+	want := []string{
+		"gallery[dimensions=1]: " + ErrInvalidSelector.Error(),
+		"gallery[path=x].unknown: " + ErrUnknownField.Error(),
+		"gallery[photo_id=x]: " + ErrInvalidSelector.Error(),
+		"gallery[unknown=1]: " + ErrInvalidSelector.Error(),
+		"login_timestamps[a=1]: " + ErrInvalidSelector.Error(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() errors = %q, want %q", got, want)
+	}
+}
+
+func TestSelectorPaths_GetSet(t *testing.T) {
+	msg := newGalleryProfile()
+	v, err := Get(msg, "gallery[photo_id=2].dimensions.width")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if v.Int() != 2 {
+		t.Errorf("Get() = %v, want 2", v)
+	}
+	if err := Set(msg, "gallery[path=`path 3`].path", protoreflect.ValueOfString("new")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if msg.Gallery[2].Path != "new" {
+		t.Errorf("Set() gallery = %v, want the path of the third photo changed", msg.Gallery)
+	}
+	if _, err := Get(msg, "gallery[photo_id=4].path"); !errors.Is(err, ErrInvalidSelector) {
+		t.Errorf("Get() error = %v, want %v", err, ErrInvalidSelector)
+	}
+}
+
+func TestSelectorPaths_Overwrite(t *testing.T) {
+	src := &testproto.Profile{Gallery: []*testproto.Photo{
+		{PhotoId: 2, Path: "new 2", Dimensions: &testproto.Dimensions{Width: 20}},
+		{PhotoId: 4, Path: "new 4", Dimensions: &testproto.Dimensions{Width: 40}},
+	}}
+	dst := newGalleryProfile()
+	paths := []string{"gallery[photo_id=2].path", "gallery[photo_id=4].dimensions", "gallery[photo_id=3]"}
+	Overwrite(dst, src, paths)
+	want := newGalleryProfile()
+	want.Gallery[1].Path = "new 2"
+	want.Gallery[2] = &testproto.Photo{PhotoId: 4, Dimensions: &testproto.Dimensions{Width: 40}}
+	if !proto.Equal(dst, want) {
+		t.Errorf("Overwrite() = %v, want %v", dst, want)
+	}
+}
+
+func TestOverwrite_WithMergeKey(t *testing.T) {
+	src := &testproto.Profile{Gallery: []*testproto.Photo{
+		{PhotoId: 2, Path: "new 2", Dimensions: &testproto.Dimensions{Width: 20}},
+		{PhotoId: 4, Path: "new 4", Dimensions: &testproto.Dimensions{Width: 40}},
+	}}
+	tests := []struct {
+		name  string
+		paths []string
+		want  func(*testproto.Profile)
+	}{
+		{
+			name:  "whole elements",
+			paths: []string{"gallery"},
+			want: func(p *testproto.Profile) {
+				p.Gallery[1] = src.Gallery[0]
+				p.Gallery = append(p.Gallery, src.Gallery[1])
+			},
+		},
+		{
+			name:  "subfields of the elements",
+			paths: []string{"gallery.path"},
+			want: func(p *testproto.Profile) {
+				p.Gallery[1].Path = "new 2"
+				p.Gallery = append(p.Gallery, &testproto.Photo{PhotoId: 4, Path: "new 4"})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := newGalleryProfile()
+			Overwrite(dst, src, tt.paths, WithMergeKey("testproto.Profile.gallery", "photo_id"))
+			want := newGalleryProfile()
+			tt.want(want)
+			if !proto.Equal(dst, want) {
+				t.Errorf("Overwrite() = %v, want %v", dst, want)
+			}
+		})
+	}
+}
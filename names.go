@@ -0,0 +1,114 @@
+package fmutils
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ProtoNames returns a copy of the mask with the JSON names of the fields replaced with their proto names, e.g.
+// "loginTimestamps" with "login_timestamps".
+//
+// The names are resolved against the given message descriptor taking the custom json_name options into account.
+// Map keys, wildcards and the names that do not match any field are kept as is. The subfields of the
+// google.protobuf.Any fields are kept as is since the type of the packed message is not known in advance.
+func (mask NestedMask) ProtoNames(md protoreflect.MessageDescriptor) NestedMask {
+	return mask.renameFields(md, func(fd protoreflect.FieldDescriptor) string {
+		return string(fd.Name())
+	})
+}
+
+// JSONNames returns a copy of the mask with the proto names of the fields replaced with their JSON names, e.g.
+// "login_timestamps" with "loginTimestamps", as in the protojson encoding of google.protobuf.FieldMask.
+//
+// It is the opposite of NestedMask.ProtoNames.
+func (mask NestedMask) JSONNames(md protoreflect.MessageDescriptor) NestedMask {
+	return mask.renameFields(md, protoreflect.FieldDescriptor.JSONName)
+}
+
+// renameFields returns a copy of the mask of a message with the fields renamed with the given function.
+func (mask NestedMask) renameFields(md protoreflect.MessageDescriptor,
+	name func(protoreflect.FieldDescriptor) string) NestedMask {
+	result := make(NestedMask, len(mask))
+	for key, m := range mask {
+		if key == exclusion {
+			result.unionChild(key, m.renameFields(md, name))
+		} else if fd := fieldByName(md, key); fd != nil {
+			result.unionChild(name(fd), m.renameValue(fd, name))
+		} else {
+			result.unionChild(key, m.clone())
+		}
+	}
+	return result
+}
+
+// renameValue returns a copy of the mask of a field with the fields of its messages renamed with the given function.
+func (mask NestedMask) renameValue(fd protoreflect.FieldDescriptor,
+	name func(protoreflect.FieldDescriptor) string) NestedMask {
+	if excludes, ok := mask[exclusion]; ok {
+		includes, _ := mask.split()
+		result := includes.renameValue(fd, name)
+		result[exclusion] = excludes.renameValue(fd, name)
+		return result
+	}
+	switch {
+	case len(mask) == 0:
+		return NestedMask{}
+	case fd.IsMap():
+		result := make(NestedMask, len(mask))
+		for key, m := range mask {
+			result[key] = m.renameElement(fd.MapValue(), name)
+		}
+		return result
+	case fd.IsList() && mask.listMask(fd).indexed():
+		l := mask.listMask(fd)
+		result := l.all.renameElement(fd, name)
+		for key, m := range mask {
+			field, value, isSelector := splitSelector(key)
+			if _, isIndex := parseIndex(key); !isSelector && !isIndex {
+				continue
+			}
+			if isSelector && fd.Message() != nil {
+				if sfd := fieldByName(fd.Message(), field); sfd != nil {
+					key = "[" + name(sfd) + "=" + value + "]"
+				}
+			}
+			result.unionChild(key, m.renameElement(fd, name))
+		}
+		return result
+	default:
+		return mask.renameElement(fd, name)
+	}
+}
+
+// renameElement returns a copy of the mask of a singular field value, a list element or a map value with the fields of
+// its messages renamed with the given function.
+func (mask NestedMask) renameElement(fd protoreflect.FieldDescriptor,
+	name func(protoreflect.FieldDescriptor) string) NestedMask {
+	switch {
+	case len(mask) == 0:
+		return NestedMask{}
+	case fd.Message() != nil && fd.Message().FullName() != anyFullName:
+		return mask.renameFields(fd.Message(), name)
+	default:
+		return mask.clone()
+	}
+}
+
+// fieldByName returns the field of the message with the given proto name or JSON name, nil if there is no such field.
+func fieldByName(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if name == wildcard {
+		return nil
+	}
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+// protoNames returns the mask with the JSON names replaced with the proto names if the WithJSONNames option is given.
+func (o *options) protoNames(mask NestedMask, md protoreflect.MessageDescriptor) NestedMask {
+	if !o.jsonNames {
+		return mask
+	}
+	return mask.ProtoNames(md)
+}
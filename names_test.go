@@ -0,0 +1,164 @@
+package fmutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestNestedMask_ProtoNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		msg   proto.Message
+		want  []string
+	}{
+		{
+			name:  "JSON names",
+			paths: []string{"loginTimestamps", "photo.photoId", "gallery.dimensions.width"},
+			msg:   &testproto.Profile{},
+			want:  []string{"gallery.dimensions.width", "login_timestamps", "photo.photo_id"},
+		},
+		{
+			name:  "map keys are kept",
+			paths: []string{"attributes.userId.tags.photoId", "attributes.*.tags"},
+			msg:   &testproto.Profile{},
+			want:  []string{"attributes.*.tags", "attributes.userId.tags.photoId"},
+		},
+		{
+			name:  "custom JSON names",
+			paths: []string{"scores.1.note", "scores.2.value", "int32Scores.3.comment"},
+			msg:   &testproto.Leaderboard{},
+			want:  []string{"int32_scores.3.comment", "scores.1.comment", "scores.2.value"},
+		},
+		{
+			name:  "both forms are merged",
+			paths: []string{"user.userId", "user.user_id", "photo.dimensions", "photo.dimensions.width"},
+			msg:   &testproto.Profile{},
+			want:  []string{"photo.dimensions", "user.user_id"},
+		},
+		{
+			name:  "unknown names and Any subfields are kept",
+			paths: []string{"eventId", "unknownField.a", "details.nextToken"},
+			msg:   &testproto.Event{},
+			want:  []string{"details.nextToken", "event_id", "unknownField.a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NestedMaskFromPaths(tt.paths).ProtoNames(tt.msg.ProtoReflect().Descriptor())
+			if !reflect.DeepEqual(got.Paths(), tt.want) {
+				t.Errorf("ProtoNames() = %v, want %v", got.Paths(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNestedMask_JSONNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		msg   proto.Message
+		want  []string
+	}{
+		{
+			name:  "proto names",
+			paths: []string{"login_timestamps", "photo.photo_id", "attributes.a_1.tags"},
+			msg:   &testproto.Profile{},
+			want:  []string{"attributes.a_1.tags", "loginTimestamps", "photo.photoId"},
+		},
+		{
+			name:  "custom JSON names",
+			paths: []string{"scores.1.comment", "int32_scores.*.comment"},
+			msg:   &testproto.Leaderboard{},
+			want:  []string{"int32Scores.*.note", "scores.1.note"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask := NestedMaskFromPaths(tt.paths)
+			md := tt.msg.ProtoReflect().Descriptor()
+			got := mask.JSONNames(md)
+			if !reflect.DeepEqual(got.Paths(), tt.want) {
+				t.Errorf("JSONNames() = %v, want %v", got.Paths(), tt.want)
+			}
+			if back := got.ProtoNames(md); !reflect.DeepEqual(back, mask) {
+				t.Errorf("ProtoNames() = %v, want %v", back, mask)
+			}
+		})
+	}
+}
+
+func TestFilter_WithJSONNames(t *testing.T) {
+	msg := &testproto.Profile{
+		User:            &testproto.User{UserId: 1, Name: "name"},
+		LoginTimestamps: []int64{1, 2},
+		Gallery:         []*testproto.Photo{{PhotoId: 1, Path: "path"}},
+	}
+	paths := []string{"user.userId", "loginTimestamps", "gallery.photo_id"}
+
+	got := proto.Clone(msg)
+	Filter(got, paths, WithJSONNames())
+	want := &testproto.Profile{
+		User:            &testproto.User{UserId: 1},
+		LoginTimestamps: []int64{1, 2},
+		Gallery:         []*testproto.Photo{{PhotoId: 1}},
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("Filter() = %v, want %v", got, want)
+	}
+
+	got = proto.Clone(msg)
+	Prune(got, paths, WithJSONNames())
+	want = &testproto.Profile{
+		User:    &testproto.User{Name: "name"},
+		Gallery: []*testproto.Photo{{Path: "path"}},
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("Prune() = %v, want %v", got, want)
+	}
+
+	wantCopy := FilterCopy(msg, []string{"user.user_id", "login_timestamps", "gallery.photo_id"})
+	if got := FilterCopy(msg, paths, WithJSONNames()); !proto.Equal(got, wantCopy) {
+		t.Errorf("FilterCopy() = %v, want %v", got, wantCopy)
+	}
+
+	mask := NestedMaskFromPaths(paths)
+	got = proto.Clone(msg)
+	mask.Compile(msg.ProtoReflect().Descriptor(), WithJSONNames()).Prune(got)
+	if !proto.Equal(got, want) {
+		t.Errorf("CompiledMask.Prune() = %v, want %v", got, want)
+	}
+
+	if err := mask.Validate(msg.ProtoReflect().Descriptor()); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Validate() error = %v, want %v", err, ErrUnknownField)
+	}
+	if err := mask.Validate(msg.ProtoReflect().Descriptor(), WithJSONNames()); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	if err := FilterChecked(proto.Clone(msg), paths, WithJSONNames()); err != nil {
+		t.Errorf("FilterChecked() error = %v, want nil", err)
+	}
+}
+
+func TestFilter_WithJSONNamesAndAnyResolver(t *testing.T) {
+	msg := &testproto.Event{
+		EventId: 1,
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+	}
+	err := FilterChecked(msg, []string{"details.nextToken"}, WithJSONNames(), WithAnyResolver(protoregistry.GlobalTypes))
+	if err != nil {
+		t.Fatalf("FilterChecked() error = %v, want nil", err)
+	}
+	want := &testproto.Event{
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{NextToken: 1})},
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
@@ -0,0 +1,120 @@
+package fmutils
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Option configures how a NestedMask is applied to a proto message.
+type Option func(*options)
+
+type options struct {
+	anyResolver protoregistry.MessageTypeResolver
+	// checked is set by the FilterChecked and PruneChecked methods to validate the masks applied to the messages
+	// packed into google.protobuf.Any fields.
+	checked   bool
+	jsonNames bool
+	// keepUnknown is set by the WithUnknownFields option.
+	keepUnknown bool
+	// clearEmpty is set by the WithClearEmpty option.
+	clearEmpty bool
+	// dryRun is set by the WithDryRun option.
+	dryRun bool
+	// report records the cleared values for NestedMask.FilterReport and NestedMask.PruneReport, nil otherwise.
+	report *Report
+	// path is the path of the value being processed, it is tracked only if the cleared values are reported.
+	path []string
+	// mergeKeys holds the key fields of the repeated fields by the full names of the repeated fields.
+	mergeKeys map[protoreflect.FullName]protoreflect.Name
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithAnyResolver makes the mask look inside the google.protobuf.Any fields.
+//
+// The type URL of an Any field is resolved with the given resolver (e.g. protoregistry.GlobalTypes), the packed
+// message is unpacked, the subfields listed in the mask are applied to it and then it is packed back.
+// If the type URL can not be resolved or the packed message can not be unpacked, then Filter and Prune reset the
+// whole Any field, so that no unchecked data is left behind, e.g. Prune of "details.data" clears all of "details".
+// The reset fields are listed in the reports of FilterReport and PruneReport, while FilterChecked, PruneChecked and
+// the methods that return an error report the failure instead.
+// Without this option an Any field is treated as a regular message with the type_url and value fields.
+func WithAnyResolver(r protoregistry.MessageTypeResolver) Option {
+	return func(o *options) {
+		o.anyResolver = r
+	}
+}
+
+// WithJSONNames makes the mask accept the JSON names of the fields, e.g. "loginTimestamps", along with their proto
+// names, e.g. "login_timestamps".
+//
+// The mask is converted with NestedMask.ProtoNames against the descriptor of the message it is applied to.
+func WithJSONNames() Option {
+	return func(o *options) {
+		o.jsonNames = true
+	}
+}
+
+// WithUnknownFields makes NestedMask.Filter keep the unknown fields of the messages it descends into.
+//
+// The unknown fields, e.g. the ones added in a newer version of the message, can not be listed in a mask, so by default
+// Filter clears them along with the other fields that are not listed. The messages listed as a whole keep their
+// unknown fields either way, and NestedMask.Prune never clears them.
+func WithUnknownFields() Option {
+	return func(o *options) {
+		o.keepUnknown = true
+	}
+}
+
+// WithClearEmpty makes NestedMask.Filter and NestedMask.Prune clear the submessages, map entries and list elements
+// that are left empty by the operation, e.g. the photo message after "photo.path" and "photo.dimensions" are pruned.
+//
+// The messages are cleared bottom-up, so a message which submessages are all cleared is cleared as well. The messages
+// that are empty to begin with are kept.
+func WithClearEmpty() Option {
+	return func(o *options) {
+		o.clearEmpty = true
+	}
+}
+
+// WithDryRun makes NestedMask.FilterReport and NestedMask.PruneReport report the values that would be cleared
+// leaving the message untouched.
+//
+// The mask is applied to a copy of the message, the other methods ignore this option.
+func WithDryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+// WithMergeKey makes NestedMask.Overwrite merge the elements of the repeated message field with the given full name,
+// e.g. "testproto.Profile.gallery", by the given key field of the elements, e.g. "photo_id", instead of replacing the
+// whole list.
+func WithMergeKey(field protoreflect.FullName, key protoreflect.Name) Option {
+	return func(o *options) {
+		if o.mergeKeys == nil {
+			o.mergeKeys = make(map[protoreflect.FullName]protoreflect.Name)
+		}
+		o.mergeKeys[field] = key
+	}
+}
+
+// mergeKey returns the key field of the elements of the repeated field given in the WithMergeKey options, nil if the
+// field is not given or the key is not a singular scalar field of the elements.
+func (o *options) mergeKey(fd protoreflect.FieldDescriptor) protoreflect.FieldDescriptor {
+	name, ok := o.mergeKeys[fd.FullName()]
+	if !ok || fd.Message() == nil {
+		return nil
+	}
+	key := fd.Message().Fields().ByName(name)
+	if key == nil || key.Cardinality() == protoreflect.Repeated || key.Message() != nil {
+		return nil
+	}
+	return key
+}
@@ -0,0 +1,311 @@
+package fmutils
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Overwrite copies the src fields that are listed in the paths into dst replacing the existing values.
+//
+// This is a handy wrapper for NestedMask.Overwrite method.
+// If the same paths are used to process multiple proto messages use NestedMask.Overwrite method directly.
+func Overwrite(dst, src proto.Message, paths []string, opts ...Option) {
+	NestedMaskFromPaths(paths).Overwrite(dst, src, opts...)
+}
+
+// Overwrite copies the src fields that are listed in the mask into dst replacing the existing values.
+//
+// This implements the update semantics described in https://google.aip.dev/134:
+//   - scalar, repeated and map fields listed in the mask are replaced rather than merged;
+//   - fields listed in the mask but not populated in src are cleared in dst;
+//   - singular message fields are recursed into if the mask lists their subfields, otherwise replaced;
+//   - map entries are recursed into if the mask lists their keys;
+//   - repeated fields are replaced, the subfields listed in the mask are kept in every copied element;
+//   - if the mask lists the elements of a repeated field by their indices, e.g. "gallery.0.path", then only these
+//     elements are overwritten in place with the src elements at the same positions;
+//   - if the mask selects the elements of a repeated field by a key field, e.g. "gallery[photo_id=2].path", then the
+//     selected dst elements are overwritten with the first selected src element, they are removed (or their listed
+//     subfields are cleared) if there is no such src element and the src element is appended if there are no such dst
+//     elements;
+//   - the repeated fields given in the WithMergeKey options are merged by the key field: the dst elements are
+//     overwritten with the src elements with the same key, the src elements with new keys are appended and the rest of
+//     the dst elements are kept.
+//
+// If the mask is empty then dst becomes a copy of src.
+// The src and dst messages must be of the same type otherwise the function panics.
+// Paths are assumed to be valid and normalized, use NestedMask.Validate for masks that come from untrusted sources.
+func (mask NestedMask) Overwrite(dst, src proto.Message, opts ...Option) {
+	dstRft, srcRft := dst.ProtoReflect(), src.ProtoReflect()
+	if dstRft.Descriptor().FullName() != srcRft.Descriptor().FullName() {
+		panic(fmt.Sprintf("fmutils: can not overwrite %s with %s",
+			dstRft.Descriptor().FullName(), srcRft.Descriptor().FullName()))
+	}
+	mask.overwrite(dstRft, srcRft, newOptions(opts))
+}
+
+func (mask NestedMask) overwrite(dst, src protoreflect.Message, o *options) {
+	if len(mask) == 0 {
+		proto.Reset(dst.Interface())
+		proto.Merge(dst.Interface(), src.Interface())
+		return
+	}
+
+	fields := dst.Descriptor().Fields()
+	if mask.matchesAny() {
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			if m, ok := mask.child(string(fd.Name())); ok {
+				m.overwriteField(dst, src, fd, o)
+			}
+		}
+		return
+	}
+	for key, m := range mask {
+		fd := fields.ByName(protoreflect.Name(key))
+		if fd == nil {
+			continue
+		}
+		m.overwriteField(dst, src, fd, o)
+	}
+}
+
+func (mask NestedMask) overwriteField(dst, src protoreflect.Message, fd protoreflect.FieldDescriptor, o *options) {
+	l := listMask{}
+	if fd.IsList() {
+		l = mask.listMask(fd)
+	}
+	switch {
+	case l.indexed():
+		mask.overwriteElements(dst, src, fd, l, o)
+	case fd.IsList() && o.mergeKey(fd) != nil:
+		mask.mergeElements(dst, src, fd, o.mergeKey(fd), o)
+	case len(mask) == 0:
+		if src.Has(fd) {
+			dst.Set(fd, copyValue(dst.NewField(fd), src.Get(fd)))
+		} else {
+			dst.Clear(fd)
+		}
+	case fd.IsList():
+		if !src.Has(fd) {
+			dst.Clear(fd)
+			return
+		}
+		list := copyValue(dst.NewField(fd), src.Get(fd))
+		if fd.Message() != nil {
+			for i := 0; i < list.List().Len(); i++ {
+				mask.Filter(list.List().Get(i).Message().Interface())
+			}
+		}
+		dst.Set(fd, list)
+	case fd.IsMap():
+		mask.overwriteMap(dst, src, fd, o)
+	case fd.Message() != nil:
+		if src.Has(fd) || dst.Has(fd) {
+			mask.overwrite(dst.Mutable(fd).Message(), src.Get(fd).Message(), o)
+		}
+	}
+}
+
+// overwriteElements overwrites the elements of the dst list that are listed by their indices or selected by their key
+// fields.
+//
+// The elements listed by their indices are overwritten with the src elements at the same positions, the negative
+// indices count from the end of the dst list and the elements that are missing in any of the lists are skipped.
+func (mask NestedMask) overwriteElements(dst, src protoreflect.Message, fd protoreflect.FieldDescriptor, l listMask,
+	o *options) {
+	srcList := src.Get(fd).List()
+	if dst.Has(fd) && src.Has(fd) {
+		dstList := dst.Mutable(fd).List()
+		for i := 0; i < dstList.Len() && i < srcList.Len(); i++ {
+			m, ok := l.position(i, dstList.Len())
+			if m, ok = l.excluded(i, dstList.Len(), dstList.Get(i), m, ok); ok {
+				m.overwriteElement(dstList, i, srcList.Get(i), o)
+			}
+		}
+	}
+	for _, s := range l.selectors {
+		if s.fd != nil {
+			s.overwrite(dst, srcList, fd, o)
+		}
+	}
+}
+
+// overwriteElement overwrites the i-th element of the dst list with the src element.
+func (mask NestedMask) overwriteElement(dst protoreflect.List, i int, src protoreflect.Value, o *options) {
+	if m, ok := dst.Get(i).Interface().(protoreflect.Message); ok && len(mask) != 0 {
+		mask.overwrite(m, src.Message(), o)
+		return
+	}
+	dst.Set(i, copyValue(dst.NewElement(), src))
+}
+
+// overwrite overwrites the dst elements selected by the selector with the first selected src element.
+func (s selector) overwrite(dst protoreflect.Message, srcList protoreflect.List, fd protoreflect.FieldDescriptor,
+	o *options) {
+	var srcElem protoreflect.Value
+	for i := 0; i < srcList.Len(); i++ {
+		if s.matches(srcList.Get(i)) {
+			srcElem = srcList.Get(i)
+			break
+		}
+	}
+
+	var dstList protoreflect.List
+	found := false
+	if dst.Has(fd) {
+		dstList = dst.Mutable(fd).List()
+		for i := 0; i < dstList.Len(); i++ {
+			if !s.matches(dstList.Get(i)) {
+				continue
+			}
+			found = true
+			if srcElem.IsValid() {
+				s.mask.overwriteElement(dstList, i, srcElem, o)
+			} else if len(s.mask) != 0 {
+				s.mask.overwrite(dstList.Get(i).Message(), dstList.NewElement().Message(), o)
+			}
+		}
+		if !srcElem.IsValid() && len(s.mask) == 0 {
+			removeElements(dstList, s.matches)
+		}
+	}
+	if found || !srcElem.IsValid() {
+		return
+	}
+	dstList = dst.Mutable(fd).List()
+	nv := dstList.NewElement()
+	s.mask.overwrite(nv.Message(), srcElem.Message(), o)
+	nv.Message().Set(s.fd, s.value)
+	dstList.Append(nv)
+}
+
+// mergeElements merges the src list elements into the dst list by the key field.
+//
+// The dst elements are overwritten with the mask by the src elements with the same keys, the src elements with new
+// keys are appended and the rest of the dst elements are kept.
+func (mask NestedMask) mergeElements(dst, src protoreflect.Message, fd, key protoreflect.FieldDescriptor, o *options) {
+	if !src.Has(fd) {
+		return
+	}
+	srcList, dstList := src.Get(fd).List(), dst.Mutable(fd).List()
+	for i := 0; i < srcList.Len(); i++ {
+		srcElem := srcList.Get(i).Message()
+		k := srcElem.Get(key)
+		found := false
+		for j := 0; j < dstList.Len(); j++ {
+			if dstElem := dstList.Get(j).Message(); dstElem.Get(key).Equal(k) {
+				mask.overwrite(dstElem, srcElem, o)
+				found = true
+			}
+		}
+		if !found {
+			nv := dstList.NewElement()
+			mask.overwrite(nv.Message(), srcElem, o)
+			nv.Message().Set(key, k)
+			dstList.Append(nv)
+		}
+	}
+}
+
+// removeElements removes the elements of the list for which remove returns true.
+func removeElements(list protoreflect.List, remove func(protoreflect.Value) bool) {
+	kept := make([]protoreflect.Value, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		if v := list.Get(i); !remove(v) {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == list.Len() {
+		return
+	}
+	list.Truncate(0)
+	for _, v := range kept {
+		list.Append(v)
+	}
+}
+
+func (mask NestedMask) overwriteMap(dst, src protoreflect.Message, fd protoreflect.FieldDescriptor, o *options) {
+	mask = mask.withMapKeys(fd.MapKey())
+	if !mask.matchesAny() {
+		for key, m := range mask {
+			mk, err := parseMapKey(fd.MapKey(), key)
+			if err != nil {
+				continue
+			}
+			m.overwriteMapEntry(dst, src, fd, mk, o)
+		}
+		return
+	}
+
+	// The wildcard or the exclusions match all the keys of both maps.
+	keys := make(map[interface{}]protoreflect.MapKey)
+	collect := func(mk protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys[mk.Interface()] = mk
+		return true
+	}
+	src.Get(fd).Map().Range(collect)
+	dst.Get(fd).Map().Range(collect)
+	for _, mk := range keys {
+		if m, ok := mask.child(mk.String()); ok {
+			m.overwriteMapEntry(dst, src, fd, mk, o)
+		}
+	}
+}
+
+// matchesAny reports whether the mask may match the keys it does not list, i.e. it has a wildcard or exclusions.
+func (mask NestedMask) matchesAny() bool {
+	_, hasWildcard := mask[wildcard]
+	_, hasExclusions := mask[exclusion]
+	return hasWildcard || hasExclusions
+}
+
+func (mask NestedMask) overwriteMapEntry(dst, src protoreflect.Message, fd protoreflect.FieldDescriptor,
+	mk protoreflect.MapKey, o *options) {
+	srcMap := src.Get(fd).Map()
+	if srcMap.Has(mk) {
+		dstMap := dst.Mutable(fd).Map()
+		if len(mask) == 0 || fd.MapValue().Message() == nil {
+			dstMap.Set(mk, copyValue(dstMap.NewValue(), srcMap.Get(mk)))
+		} else {
+			mask.overwrite(dstMap.Mutable(mk).Message(), srcMap.Get(mk).Message(), o)
+		}
+		return
+	}
+	if !dst.Has(fd) || !dst.Get(fd).Map().Has(mk) {
+		return
+	}
+	dstMap := dst.Mutable(fd).Map()
+	if len(mask) == 0 || fd.MapValue().Message() == nil {
+		dstMap.Clear(mk)
+	} else {
+		mask.overwrite(dstMap.Mutable(mk).Message(), dstMap.NewValue().Message(), o)
+	}
+}
+
+// copyValue deep copies the src value into dst which must be a newly created value of the same field.
+func copyValue(dst, src protoreflect.Value) protoreflect.Value {
+	switch v := src.Interface().(type) {
+	case protoreflect.Message:
+		proto.Merge(dst.Message().Interface(), v.Interface())
+		return dst
+	case protoreflect.List:
+		list := dst.List()
+		for i := 0; i < v.Len(); i++ {
+			list.Append(copyValue(list.NewElement(), v.Get(i)))
+		}
+		return dst
+	case protoreflect.Map:
+		xmap := dst.Map()
+		v.Range(func(mk protoreflect.MapKey, mv protoreflect.Value) bool {
+			xmap.Set(mk, copyValue(xmap.NewValue(), mv))
+			return true
+		})
+		return dst
+	case []byte:
+		return protoreflect.ValueOfBytes(append([]byte(nil), v...))
+	default:
+		return src
+	}
+}
@@ -0,0 +1,271 @@
+package fmutils
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestOverwrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		dst   proto.Message
+		src   proto.Message
+		want  proto.Message
+	}{
+		{
+			name:  "empty mask replaces the entire message",
+			paths: []string{},
+			dst: &testproto.Profile{
+				User:            &testproto.User{UserId: 1, Name: "name"},
+				LoginTimestamps: []int64{1, 2},
+			},
+			src: &testproto.Profile{
+				Photo: &testproto.Photo{PhotoId: 2},
+			},
+			want: &testproto.Profile{
+				Photo: &testproto.Photo{PhotoId: 2},
+			},
+		},
+		{
+			name:  "scalar fields are replaced",
+			paths: []string{"user.name", "photo.dimensions.width"},
+			dst: &testproto.Profile{
+				User:  &testproto.User{UserId: 1, Name: "name"},
+				Photo: &testproto.Photo{PhotoId: 1, Dimensions: &testproto.Dimensions{Width: 100, Height: 120}},
+			},
+			src: &testproto.Profile{
+				User:  &testproto.User{UserId: 2, Name: "new name"},
+				Photo: &testproto.Photo{PhotoId: 2, Dimensions: &testproto.Dimensions{Width: 50, Height: 60}},
+			},
+			want: &testproto.Profile{
+				User:  &testproto.User{UserId: 1, Name: "new name"},
+				Photo: &testproto.Photo{PhotoId: 1, Dimensions: &testproto.Dimensions{Width: 50, Height: 120}},
+			},
+		},
+		{
+			name:  "repeated fields are replaced",
+			paths: []string{"login_timestamps", "gallery"},
+			dst: &testproto.Profile{
+				LoginTimestamps: []int64{1, 2, 3},
+				Gallery:         []*testproto.Photo{{PhotoId: 1}, {PhotoId: 2}},
+			},
+			src: &testproto.Profile{
+				LoginTimestamps: []int64{4, 5},
+				Gallery:         []*testproto.Photo{{PhotoId: 3}},
+			},
+			want: &testproto.Profile{
+				LoginTimestamps: []int64{4, 5},
+				Gallery:         []*testproto.Photo{{PhotoId: 3}},
+			},
+		},
+		{
+			name:  "repeated message fields are replaced with masked elements",
+			paths: []string{"gallery.path"},
+			dst: &testproto.Profile{
+				Gallery: []*testproto.Photo{{PhotoId: 1, Path: "path 1"}, {PhotoId: 2, Path: "path 2"}},
+			},
+			src: &testproto.Profile{
+				Gallery: []*testproto.Photo{{PhotoId: 3, Path: "path 3"}},
+			},
+			want: &testproto.Profile{
+				Gallery: []*testproto.Photo{{Path: "path 3"}},
+			},
+		},
+		{
+			name:  "masked fields absent in src are cleared",
+			paths: []string{"user", "photo.path", "login_timestamps", "gallery"},
+			dst: &testproto.Profile{
+				User:            &testproto.User{UserId: 1, Name: "name"},
+				Photo:           &testproto.Photo{PhotoId: 1, Path: "path"},
+				LoginTimestamps: []int64{1, 2, 3},
+				Gallery:         []*testproto.Photo{{PhotoId: 1}},
+			},
+			src: &testproto.Profile{},
+			want: &testproto.Profile{
+				Photo: &testproto.Photo{PhotoId: 1},
+			},
+		},
+		{
+			name:  "singular message fields are replaced rather than merged",
+			paths: []string{"photo"},
+			dst: &testproto.Profile{
+				Photo: &testproto.Photo{PhotoId: 1, Path: "path", Dimensions: &testproto.Dimensions{Width: 100}},
+			},
+			src: &testproto.Profile{
+				Photo: &testproto.Photo{Dimensions: &testproto.Dimensions{Height: 50}},
+			},
+			want: &testproto.Profile{
+				Photo: &testproto.Photo{Dimensions: &testproto.Dimensions{Height: 50}},
+			},
+		},
+		{
+			name:  "absent submessages are not created",
+			paths: []string{"photo.path", "user.name"},
+			dst: &testproto.Profile{
+				User: &testproto.User{UserId: 1},
+			},
+			src: &testproto.Profile{},
+			want: &testproto.Profile{
+				User: &testproto.User{UserId: 1},
+			},
+		},
+		{
+			name:  "map fields are replaced",
+			paths: []string{"attributes"},
+			dst: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1"}},
+					"a2": {Tags: map[string]string{"t2": "2"}},
+				},
+			},
+			src: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t3": "3"}},
+				},
+			},
+			want: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t3": "3"}},
+				},
+			},
+		},
+		{
+			name:  "map entries are replaced by key",
+			paths: []string{"attributes.a1", "attributes.a2", "attributes.a3.tags.t1", "attributes.a4.tags"},
+			dst: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1"}},
+					"a2": {Tags: map[string]string{"t2": "2"}},
+					"a3": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+					"a4": {Tags: map[string]string{"t4": "4"}},
+					"a5": {Tags: map[string]string{"t5": "5"}},
+				},
+			},
+			src: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t3": "3"}},
+					"a3": {Tags: map[string]string{"t1": "new 1", "t2": "new 2"}},
+				},
+			},
+			want: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t3": "3"}},
+					"a3": {Tags: map[string]string{"t1": "new 1", "t2": "2"}},
+					"a4": {},
+					"a5": {Tags: map[string]string{"t5": "5"}},
+				},
+			},
+		},
+		{
+			name:  "map entries with integer keys are replaced by key",
+			paths: []string{"scores.+01", "scores.2.value", "scores.03", "bool_scores.true"},
+			dst: &testproto.Leaderboard{
+				Scores:     map[int64]*testproto.Score{1: {Value: 1}, 2: {Value: 2, Comment: "c"}, 3: {Value: 3}},
+				BoolScores: map[bool]*testproto.Score{true: {Value: 1}, false: {Value: 2}},
+			},
+			src: &testproto.Leaderboard{
+				Scores: map[int64]*testproto.Score{1: {Value: 10}, 2: {Value: 20}},
+			},
+			want: &testproto.Leaderboard{
+				Scores:     map[int64]*testproto.Score{1: {Value: 10}, 2: {Value: 20, Comment: "c"}},
+				BoolScores: map[bool]*testproto.Score{false: {Value: 2}},
+			},
+		},
+		{
+			name:  "oneof field is switched",
+			paths: []string{"user", "photo"},
+			dst: &testproto.Event{
+				EventId: 1,
+				Changed: &testproto.Event_Photo{Photo: &testproto.Photo{PhotoId: 1}},
+			},
+			src: &testproto.Event{
+				EventId: 2,
+				Changed: &testproto.Event_User{User: &testproto.User{UserId: 1}},
+			},
+			want: &testproto.Event{
+				EventId: 1,
+				Changed: &testproto.Event_User{User: &testproto.User{UserId: 1}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := proto.Clone(tt.src)
+			Overwrite(tt.dst, tt.src, tt.paths)
+			if !proto.Equal(tt.dst, tt.want) {
+				t.Errorf("dst %v, want %v", tt.dst, tt.want)
+			}
+			if !proto.Equal(tt.src, src) {
+				t.Errorf("src %v, want %v", tt.src, src)
+			}
+		})
+	}
+}
+
+func TestOverwrite_copiesValues(t *testing.T) {
+	dst := &testproto.Profile{}
+	src := &testproto.Profile{
+		Photo:   &testproto.Photo{Path: "path"},
+		Gallery: []*testproto.Photo{{Path: "path"}},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "1"}},
+		},
+	}
+	Overwrite(dst, src, []string{"photo", "gallery", "attributes.a1"})
+	dst.Photo.Path = "new path"
+	dst.Gallery[0].Path = "new path"
+	dst.Attributes["a1"].Tags["t1"] = "new 1"
+
+	want := &testproto.Profile{
+		Photo:   &testproto.Photo{Path: "path"},
+		Gallery: []*testproto.Photo{{Path: "path"}},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "1"}},
+		},
+	}
+	if !proto.Equal(src, want) {
+		t.Errorf("src %v, want %v", src, want)
+	}
+}
+
+func TestOverwrite_mismatchingTypes(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Overwrite() did not panic")
+		}
+	}()
+	Overwrite(&testproto.Profile{}, &testproto.User{}, []string{"name"})
+}
+
+func TestOverwrite_wildcard(t *testing.T) {
+	dst := &testproto.Profile{
+		User: &testproto.User{UserId: 1, Name: "name"},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+			"a2": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+		},
+	}
+	src := &testproto.Profile{
+		User: &testproto.User{Name: "new name"},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "new 1", "t2": "new 2"}},
+			"a3": {Tags: map[string]string{"t1": "new 1", "t2": "new 2"}},
+		},
+	}
+	Overwrite(dst, src, []string{"user.*", "attributes.*.tags.t1", "attributes.a1.tags.t2"})
+	want := &testproto.Profile{
+		User: &testproto.User{Name: "new name"},
+		Attributes: map[string]*testproto.Attribute{
+			"a1": {Tags: map[string]string{"t1": "new 1", "t2": "new 2"}},
+			"a2": {Tags: map[string]string{"t2": "2"}},
+			"a3": {Tags: map[string]string{"t1": "new 1"}},
+		},
+	}
+	if !proto.Equal(dst, want) {
+		t.Errorf("dst %v, want %v", dst, want)
+	}
+}
@@ -0,0 +1,290 @@
+package fmutils
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError describes a malformed path.
+type SyntaxError struct {
+	// Path is the malformed path.
+	Path string
+	// Offset is the byte offset in the Path at which the error was detected.
+	Offset int
+	// Msg describes the error.
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("fmutils: malformed path %q at offset %d: %s", e.Path, e.Offset, e.Msg)
+}
+
+// ParseNestedMask creates an instance of NestedMask for the given paths returning a *SyntaxError for the first
+// malformed path.
+//
+// Path segments are separated with dots. A segment that is not a valid identifier, e.g. a map key that contains dots,
+// must be quoted with backticks, a backtick inside a quoted segment is escaped with another backtick:
+//
+//	labels.`example.com`
+//	labels.`key with a `` backtick`
+//
+// A segment of a repeated message field may be followed by a selector of the elements which field has the given value,
+// the value is quoted with backticks if it contains brackets or backticks:
+//
+//	gallery[photo_id=2].path
+//	gallery[path=`[draft]`]
+//
+// A path with the leading '-' is excluded from the mask, so that a subtree can be listed with some of its fields
+// carved out of it. The excluded paths do not list anything on their own, use "*" to list everything except them:
+//
+//	profile
+//	-profile.user.user_id
+//	*
+//	-profile.user.user_id
+//
+// Unlike NestedMaskFromPaths empty segments and segments that are not valid UTF-8 are not allowed.
+func ParseNestedMask(paths []string) (NestedMask, error) {
+	mask := make(NestedMask)
+	for _, path := range paths {
+		segments, excluded, err := parsePath(path, false)
+		if err != nil {
+			return nil, err
+		}
+		mask.addPath(segments, excluded)
+	}
+	return mask, nil
+}
+
+// parsePath splits the path into unquoted segments, excluded is true if the path has the leading '-'.
+//
+// If lenient is true then a malformed path is split on dots as is instead of being reported as an error.
+func parsePath(path string, lenient bool) (segments []string, excluded bool, err error) {
+	excluded = strings.HasPrefix(path, exclusionPrefix)
+	p := path
+	if excluded {
+		p = path[len(exclusionPrefix):]
+	}
+	segments, err = splitPath(p, lenient)
+	switch se, ok := err.(*SyntaxError); {
+	case ok && lenient:
+		return splitLiteral(p), excluded, nil
+	case ok && excluded:
+		se.Path, se.Offset = path, se.Offset+len(exclusionPrefix)
+	}
+	return segments, excluded, err
+}
+
+// splitLiteral splits the path on dots skipping the empty segments, the backticks and brackets are kept as they are.
+//
+// The invalid UTF-8 bytes are replaced with utf8.RuneError.
+func splitLiteral(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, strings.ToValidUTF8(segment, string(utf8.RuneError)))
+		}
+	}
+	return segments
+}
+
+// addPath adds the path given as a list of segments to the mask or to its exclusions.
+func (mask NestedMask) addPath(segments []string, excluded bool) {
+	if !excluded {
+		mask.add(segments)
+		return
+	}
+	if len(segments) == 0 {
+		return
+	}
+	excludes, ok := mask[exclusion]
+	if !ok {
+		excludes = make(NestedMask)
+		mask[exclusion] = excludes
+	}
+	excludes.add(segments)
+}
+
+// add adds the path given as a list of segments to the mask.
+//
+// A path that lists a field as a whole takes precedence over the paths that list its subfields.
+func (mask NestedMask) add(segments []string) {
+	if len(segments) == 0 {
+		return
+	}
+	curr := mask
+	for _, key := range segments[:len(segments)-1] {
+		c, ok := curr[key]
+		if !ok {
+			c = make(NestedMask)
+			curr[key] = c
+		} else if len(c) == 0 {
+			// The field is already listed as a whole.
+			return
+		}
+		curr = c
+	}
+	curr[segments[len(segments)-1]] = make(NestedMask)
+}
+
+// splitPath splits the path into unquoted segments.
+//
+// A selector that follows a segment, e.g. "gallery[photo_id=2]", becomes a separate "[photo_id=2]" segment.
+// If lenient is true then empty unquoted segments are skipped instead of being reported as errors.
+func splitPath(path string, lenient bool) ([]string, error) {
+	var segments []string
+	for i := 0; i <= len(path); i++ {
+		afterSegment := "expected '.' after a quoted segment"
+		switch {
+		case i < len(path) && path[i] == '`':
+			segment, end, err := unquoteSegment(path, i)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case segment == wildcard:
+				return nil, &SyntaxError{Path: path, Offset: i, Msg: "the wildcard can not be quoted"}
+			case !utf8.ValidString(segment):
+				return nil, &SyntaxError{Path: path, Offset: i, Msg: "invalid UTF-8 in a segment"}
+			}
+			segments = append(segments, segment)
+			i = end
+		default:
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				if path[end] == '`' {
+					return nil, &SyntaxError{Path: path, Offset: end, Msg: "unexpected '`' in an unquoted segment"}
+				}
+				end++
+			}
+			switch {
+			case !utf8.ValidString(path[i:end]):
+				return nil, &SyntaxError{Path: path, Offset: i, Msg: "invalid UTF-8 in a segment"}
+			case end != i:
+				segments = append(segments, path[i:end])
+			case end < len(path) && path[end] == '[':
+			case lenient:
+				continue
+			default:
+				return nil, &SyntaxError{Path: path, Offset: i, Msg: "empty segment"}
+			}
+			i = end
+		}
+		for i < len(path) && path[i] == '[' {
+			selector, end, err := parseSelector(path, i)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, selector)
+			i = end
+			afterSegment = "expected '.' after a selector"
+		}
+		if i < len(path) && path[i] != '.' {
+			return nil, &SyntaxError{Path: path, Offset: i, Msg: afterSegment}
+		}
+	}
+	return segments, nil
+}
+
+// parseSelector parses the selector that starts with '[' at the given offset.
+//
+// It returns the selector segment with the value unquoted and the offset right after the closing ']'.
+func parseSelector(path string, start int) (string, int, error) {
+	i := start + 1
+	for i < len(path) && path[i] != '=' && !strings.ContainsRune(".[]`", rune(path[i])) {
+		i++
+	}
+	switch {
+	case i >= len(path) || path[i] != '=':
+		return "", 0, &SyntaxError{Path: path, Offset: i, Msg: "expected '=' in a selector"}
+	case i == start+1:
+		return "", 0, &SyntaxError{Path: path, Offset: i, Msg: "empty selector field"}
+	}
+	field := path[start+1 : i]
+
+	var value string
+	i++
+	if i < len(path) && path[i] == '`' {
+		v, end, err := unquoteSegment(path, i)
+		if err != nil {
+			return "", 0, err
+		}
+		value, i = v, end
+	} else {
+		end := i
+		for end < len(path) && !strings.ContainsRune("[]`", rune(path[end])) {
+			end++
+		}
+		value, i = path[i:end], end
+	}
+	if i >= len(path) || path[i] != ']' {
+		return "", 0, &SyntaxError{Path: path, Offset: i, Msg: "expected ']' at the end of a selector"}
+	}
+	return "[" + field + "=" + value + "]", i + 1, nil
+}
+
+// unquoteSegment unquotes the segment that starts with a backtick at the given offset.
+//
+// It returns the unquoted segment and the offset right after the closing backtick.
+func unquoteSegment(path string, start int) (string, int, error) {
+	var sb strings.Builder
+	for i := start + 1; i < len(path); i++ {
+		if path[i] != '`' {
+			sb.WriteByte(path[i])
+			continue
+		}
+		if i+1 < len(path) && path[i+1] == '`' {
+			sb.WriteByte('`')
+			i++
+			continue
+		}
+		return sb.String(), i + 1, nil
+	}
+	return "", 0, &SyntaxError{Path: path, Offset: start, Msg: "unterminated quoted segment"}
+}
+
+// quoteSegment quotes the segment with backticks if it is not a valid unquoted segment.
+func quoteSegment(segment string) string {
+	if segment != "" && !strings.ContainsAny(segment, ".`[") {
+		return segment
+	}
+	return quote(segment)
+}
+
+// quote quotes the string with backticks escaping the backticks inside.
+func quote(s string) string {
+	return "`" + strings.Replace(s, "`", "``", -1) + "`"
+}
+
+// joinPath joins the segments into a path quoting them if needed, the selectors are attached to the preceding
+// segments.
+func joinPath(segments []string) string {
+	var sb strings.Builder
+	for i, segment := range segments {
+		if field, value, ok := splitSelector(segment); ok {
+			if strings.ContainsAny(value, "[]`") {
+				value = quote(value)
+			}
+			sb.WriteString("[" + field + "=" + value + "]")
+			continue
+		}
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(quoteSegment(segment))
+	}
+	return sb.String()
+}
+
+// splitSelector splits the selector segment, e.g. "[photo_id=2]", into the field name and the value, ok is false if
+// the segment is not a selector.
+func splitSelector(segment string) (field, value string, ok bool) {
+	if len(segment) < 2 || segment[0] != '[' || segment[len(segment)-1] != ']' {
+		return "", "", false
+	}
+	eq := strings.IndexByte(segment, '=')
+	if eq < 0 {
+		return "", "", false
+	}
+	return segment[1:eq], segment[eq+1 : len(segment)-1], true
+}
@@ -0,0 +1,112 @@
+package fmutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestParseNestedMask(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		want    NestedMask
+		wantErr *SyntaxError
+	}{
+		{
+			name:  "nested fields",
+			paths: []string{"aaa.bb.c", "dd.e", "f", "*"},
+			want: NestedMask{
+				"aaa": NestedMask{"bb": NestedMask{"c": NestedMask{}}},
+				"dd":  NestedMask{"e": NestedMask{}},
+				"f":   NestedMask{},
+				"*":   NestedMask{}},
+		},
+		{
+			name:  "quoted segments",
+			paths: []string{"labels.`example.com`", "labels.```quoted```.a", "labels.``"},
+			want: NestedMask{"labels": NestedMask{
+				"example.com": NestedMask{},
+				"`quoted`":    NestedMask{"a": NestedMask{}},
+				"":            NestedMask{}}},
+		},
+		{
+			name:    "empty path",
+			paths:   []string{""},
+			wantErr: &SyntaxError{Path: "", Offset: 0, Msg: "empty segment"},
+		},
+		{
+			name:    "empty segment",
+			paths:   []string{"a", "b..c"},
+			wantErr: &SyntaxError{Path: "b..c", Offset: 2, Msg: "empty segment"},
+		},
+		{
+			name:    "trailing dot",
+			paths:   []string{"a.`b`."},
+			wantErr: &SyntaxError{Path: "a.`b`.", Offset: 6, Msg: "empty segment"},
+		},
+		{
+			name:    "unterminated quoted segment",
+			paths:   []string{"a.`b.c"},
+			wantErr: &SyntaxError{Path: "a.`b.c", Offset: 2, Msg: "unterminated quoted segment"},
+		},
+		{
+			name:    "backtick in an unquoted segment",
+			paths:   []string{"a.b`c`"},
+			wantErr: &SyntaxError{Path: "a.b`c`", Offset: 3, Msg: "unexpected '`' in an unquoted segment"},
+		},
+		{
+			name:    "characters after a quoted segment",
+			paths:   []string{"a.`b`c"},
+			wantErr: &SyntaxError{Path: "a.`b`c", Offset: 5, Msg: "expected '.' after a quoted segment"},
+		},
+		{
+			name:    "quoted wildcard",
+			paths:   []string{"a.`*`"},
+			wantErr: &SyntaxError{Path: "a.`*`", Offset: 2, Msg: "the wildcard can not be quoted"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNestedMask(tt.paths)
+			if tt.wantErr != nil {
+				var serr *SyntaxError
+				if !errors.As(err, &serr) || !reflect.DeepEqual(serr, tt.wantErr) {
+					t.Errorf("ParseNestedMask() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseNestedMask() error = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseNestedMask() = %v, want %v", got, tt.want)
+			}
+			if paths := got.Paths(); !reflect.DeepEqual(NestedMaskFromPaths(paths), got) {
+				t.Errorf("NestedMaskFromPaths(%v) = %v, want %v", paths, NestedMaskFromPaths(paths), got)
+			}
+		})
+	}
+}
+
+func TestFilter_quotedMapKeys(t *testing.T) {
+	msg := &testproto.Profile{
+		Attributes: map[string]*testproto.Attribute{
+			"example.com": {Tags: map[string]string{"a.b": "1", "c": "2"}},
+			"example":     {Tags: map[string]string{"a.b": "1"}},
+		},
+	}
+	Filter(msg, []string{"attributes.`example.com`.tags.`a.b`"})
+	want := &testproto.Profile{
+		Attributes: map[string]*testproto.Attribute{
+			"example.com": {Tags: map[string]string{"a.b": "1"}},
+		},
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
@@ -0,0 +1,123 @@
+package fmutils
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// RedactionMask returns the mask of the fields of the message type that hold sensitive data, it is meant to be used
+// with NestedMask.Prune, e.g. before logging the messages.
+//
+// A field is sensitive if it is marked with the debug_redact option or with any of the given FieldOptions extensions,
+// e.g. a custom (sensitive) = true option. A bool extension marks the field if it is true, an extension of any other
+// type marks the field if it is set.
+// The singular message fields, repeated message fields and map values are inspected recursively, the sensitive fields
+// of the map values are listed under the wildcard key. A field of a recursive message type that recurs in a path is
+// listed as a whole if the type has sensitive fields, so that the mask is finite and no sensitive data is left at any
+// depth.
+func RedactionMask(md protoreflect.MessageDescriptor, exts ...protoreflect.ExtensionType) NestedMask {
+	r := &redaction{
+		exts:      exts,
+		visiting:  make(map[protoreflect.FullName]bool),
+		sensitive: make(map[protoreflect.FullName]bool),
+	}
+	return r.message(md)
+}
+
+type redaction struct {
+	exts []protoreflect.ExtensionType
+	// visiting holds the message types that are being inspected in the current path.
+	visiting map[protoreflect.FullName]bool
+	// sensitive holds whether the message types have sensitive fields at any depth.
+	sensitive map[protoreflect.FullName]bool
+}
+
+// message returns the mask of the sensitive fields of the message type.
+func (r *redaction) message(md protoreflect.MessageDescriptor) NestedMask {
+	mask := make(NestedMask)
+	r.visiting[md.FullName()] = true
+	defer delete(r.visiting, md.FullName())
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		vmd := valueMessage(fd)
+		switch {
+		case r.marked(fd):
+			mask[string(fd.Name())] = NestedMask{}
+		case vmd == nil:
+		case r.visiting[vmd.FullName()]:
+			if r.hasSensitive(vmd) {
+				mask[string(fd.Name())] = NestedMask{}
+			}
+		default:
+			m := r.message(vmd)
+			switch {
+			case len(m) == 0:
+			case fd.IsMap():
+				mask[string(fd.Name())] = NestedMask{wildcard: m}
+			default:
+				mask[string(fd.Name())] = m
+			}
+		}
+	}
+	return mask
+}
+
+// hasSensitive reports whether the message type has sensitive fields at any depth.
+func (r *redaction) hasSensitive(md protoreflect.MessageDescriptor) bool {
+	s, ok := r.sensitive[md.FullName()]
+	if !ok {
+		s = r.reachesSensitive(md, make(map[protoreflect.FullName]bool))
+		r.sensitive[md.FullName()] = s
+	}
+	return s
+}
+
+// reachesSensitive reports whether a sensitive field is reachable from the message type skipping the seen types.
+func (r *redaction) reachesSensitive(md protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) bool {
+	if seen[md.FullName()] {
+		return false
+	}
+	seen[md.FullName()] = true
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if r.marked(fd) {
+			return true
+		}
+		if vmd := valueMessage(fd); vmd != nil && r.reachesSensitive(vmd, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueMessage returns the message type of the field values or the map values, nil if they are not messages.
+func valueMessage(fd protoreflect.FieldDescriptor) protoreflect.MessageDescriptor {
+	if fd.IsMap() {
+		return fd.MapValue().Message()
+	}
+	return fd.Message()
+}
+
+// marked reports whether the field is marked with the debug_redact option or any of the extensions.
+func (r *redaction) marked(fd protoreflect.FieldDescriptor) bool {
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	if !ok || opts == nil {
+		return false
+	}
+	if opts.GetDebugRedact() {
+		return true
+	}
+	for _, xt := range r.exts {
+		if !proto.HasExtension(opts, xt) {
+			continue
+		}
+		if v, ok := proto.GetExtension(opts, xt).(bool); !ok || v {
+			return true
+		}
+	}
+	return false
+}
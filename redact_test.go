@@ -0,0 +1,88 @@
+package fmutils
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestRedactionMask(t *testing.T) {
+	tests := []struct {
+		name string
+		md   protoreflect.MessageDescriptor
+		exts []protoreflect.ExtensionType
+		want []string
+	}{
+		{
+			name: "debug_redact fields",
+			md:   (&testproto.Account{}).ProtoReflect().Descriptor(),
+			want: []string{
+				"credentials.secret",
+				"history.secret",
+				"parent",
+				"password",
+				"tokens.*.secret",
+			},
+		},
+		{
+			name: "debug_redact and custom option fields",
+			md:   (&testproto.Account{}).ProtoReflect().Descriptor(),
+			exts: []protoreflect.ExtensionType{testproto.E_Sensitive},
+			want: []string{
+				"credentials.secret",
+				"email",
+				"history.secret",
+				"parent",
+				"password",
+				"secrets",
+				"tokens.*.secret",
+			},
+		},
+		{
+			name: "recursive type without sensitive fields",
+			md:   (&descriptorpb.DescriptorProto{}).ProtoReflect().Descriptor(),
+			want: nil,
+		},
+		{
+			name: "no sensitive fields",
+			md:   (&testproto.Profile{}).ProtoReflect().Descriptor(),
+			exts: []protoreflect.ExtensionType{testproto.E_Sensitive},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactionMask(tt.md, tt.exts...).Paths(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RedactionMask() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactionMask_Prune(t *testing.T) {
+	msg := &testproto.Account{
+		AccountId:   1,
+		Password:    "password",
+		Email:       "email",
+		Credentials: &testproto.Credentials{Login: "login", Secret: "secret"},
+		History:     []*testproto.Credentials{{Login: "login 1", Secret: "secret 1"}, {Login: "login 2"}},
+		Tokens:      map[string]*testproto.Credentials{"t1": {Login: "login", Secret: "secret"}},
+		Secrets:     map[string]string{"s1": "secret"},
+		Parent:      &testproto.Account{AccountId: 2, Password: "password"},
+	}
+	RedactionMask(msg.ProtoReflect().Descriptor(), testproto.E_Sensitive).Prune(msg)
+	want := &testproto.Account{
+		AccountId:   1,
+		Credentials: &testproto.Credentials{Login: "login"},
+		History:     []*testproto.Credentials{{Login: "login 1"}, {Login: "login 2"}},
+		Tokens:      map[string]*testproto.Credentials{"t1": {Login: "login"}},
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
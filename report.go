@@ -0,0 +1,188 @@
+package fmutils
+
+import (
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Report lists the values cleared by NestedMask.FilterReport and NestedMask.PruneReport.
+type Report struct {
+	// Paths holds the full paths of the cleared values, e.g. "user.name", "attributes.a1" or "gallery.0", in the
+	// order of the field numbers, the map keys and the list indices, so that the same call always gives the same
+	// Paths. The list elements are given by their indices before the operation. The segments are quoted like in
+	// NestedMask.Paths.
+	Paths []string
+	// Fields is the number of the cleared fields.
+	Fields int
+	// MapEntries is the number of the cleared map entries.
+	MapEntries int
+	// ListElements is the number of the removed list elements.
+	ListElements int
+	// UnknownFields lists the unknown fields cleared by NestedMask.FilterReport, e.g. the ones added in a newer
+	// version of the message, by the messages they are cleared from in the order the messages are visited.
+	UnknownFields []UnknownFields
+}
+
+// UnknownFields describes the unknown fields cleared from a message.
+type UnknownFields struct {
+	// Path is the full path of the message the fields are cleared from, empty for the message the mask is applied to.
+	Path string
+	// Numbers holds the sorted distinct numbers of the cleared fields.
+	Numbers []protoreflect.FieldNumber
+}
+
+// FilterReport is the same as Filter except that it returns a Report of the cleared values.
+//
+// This is a handy wrapper for NestedMask.FilterReport method.
+func FilterReport(msg proto.Message, paths []string, opts ...Option) Report {
+	return NestedMaskFromPaths(paths).FilterReport(msg, opts...)
+}
+
+// PruneReport is the same as Prune except that it returns a Report of the cleared values.
+//
+// This is a handy wrapper for NestedMask.PruneReport method.
+func PruneReport(msg proto.Message, paths []string, opts ...Option) Report {
+	return NestedMaskFromPaths(paths).PruneReport(msg, opts...)
+}
+
+// FilterReport is the same as NestedMask.Filter except that it returns a Report of the cleared values.
+//
+// The fields of the messages packed into google.protobuf.Any fields are reported if the WithAnyResolver option is
+// given, the cleared unknown fields are reported by their numbers. If the WithDryRun option is given then the msg is
+// left untouched.
+func (mask NestedMask) FilterReport(msg proto.Message, opts ...Option) Report {
+	rft, o := reportOptions(msg, opts)
+	_ = o.protoNames(mask, rft.Descriptor()).filter(rft, o)
+	return *o.report
+}
+
+// PruneReport is the same as NestedMask.Prune except that it returns a Report of the cleared values.
+//
+// The fields of the messages packed into google.protobuf.Any fields are reported if the WithAnyResolver option is
+// given. If the WithDryRun option is given then the msg is left untouched.
+func (mask NestedMask) PruneReport(msg proto.Message, opts ...Option) Report {
+	rft, o := reportOptions(msg, opts)
+	_ = o.protoNames(mask, rft.Descriptor()).prune(rft, o)
+	return *o.report
+}
+
+// reportOptions returns the message to apply the mask to and the options that record the cleared values, the
+// message is a copy of msg if the WithDryRun option is given.
+func reportOptions(msg proto.Message, opts []Option) (protoreflect.Message, *options) {
+	o := newOptions(opts)
+	o.report = &Report{}
+	if o.dryRun {
+		msg = proto.Clone(msg)
+	}
+	return msg.ProtoReflect(), o
+}
+
+// rangeFields calls fn for every populated field of the message until fn returns false, the fields are visited in the
+// order of their numbers if the cleared values are reported.
+func (o *options) rangeFields(m protoreflect.Message, fn func(protoreflect.FieldDescriptor) bool) {
+	if o.report == nil {
+		m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+			return fn(fd)
+		})
+		return
+	}
+	for _, fd := range populatedFields(m) {
+		if !fn(fd) {
+			return
+		}
+	}
+}
+
+// rangeMap calls fn for every entry of the map until fn returns false, the entries are visited in the order of their
+// keys if the cleared values are reported.
+func (o *options) rangeMap(xmap protoreflect.Map, fn func(protoreflect.MapKey, protoreflect.Value) bool) {
+	if o.report == nil {
+		xmap.Range(fn)
+		return
+	}
+	for _, mk := range sortedMapKeys(xmap) {
+		if !fn(mk, xmap.Get(mk)) {
+			return
+		}
+	}
+}
+
+// enter appends the segments to the path of the value being processed if the cleared values are reported.
+func (o *options) enter(segments ...string) {
+	if o.report != nil {
+		o.path = append(o.path, segments...)
+	}
+}
+
+// leave removes the last n segments from the path of the value being processed.
+func (o *options) leave(n int) {
+	if o.report != nil {
+		o.path = o.path[:len(o.path)-n]
+	}
+}
+
+// clearField clears the field of the message and records it.
+func (o *options) clearField(m protoreflect.Message, fd protoreflect.FieldDescriptor) {
+	m.Clear(fd)
+	if o.report != nil {
+		o.report.Fields++
+		o.record(string(fd.Name()))
+	}
+}
+
+// clearEntry clears the entry of the map field and records it.
+func (o *options) clearEntry(xmap protoreflect.Map, fd protoreflect.FieldDescriptor, mk protoreflect.MapKey) {
+	xmap.Clear(mk)
+	if o.report != nil {
+		o.report.MapEntries++
+		o.record(string(fd.Name()), mk.String())
+	}
+}
+
+// removedElement records the removed i-th element of the list being processed.
+func (o *options) removedElement(i int) {
+	if o.report != nil {
+		o.report.ListElements++
+		o.record(strconv.Itoa(i))
+	}
+}
+
+// clearUnknown clears the unknown fields of the message and records their numbers.
+func (o *options) clearUnknown(m protoreflect.Message) {
+	if o.report != nil {
+		o.report.UnknownFields = append(o.report.UnknownFields, UnknownFields{
+			Path:    joinPath(o.path),
+			Numbers: fieldNumbers(m.GetUnknown()),
+		})
+	}
+	m.SetUnknown(nil)
+}
+
+// fieldNumbers returns the sorted distinct numbers of the fields in the raw wire format, the malformed rest of the
+// fields is skipped.
+func fieldNumbers(b []byte) []protoreflect.FieldNumber {
+	seen := make(map[protoreflect.FieldNumber]bool)
+	var numbers []protoreflect.FieldNumber
+	for len(b) > 0 {
+		num, _, n := protowire.ConsumeField(b)
+		if n < 0 {
+			break
+		}
+		if !seen[num] {
+			seen[num] = true
+			numbers = append(numbers, num)
+		}
+		b = b[n:]
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers
+}
+
+// record adds the path of the cleared value given by the segments relative to the path of the value being processed.
+func (o *options) record(segments ...string) {
+	o.report.Paths = append(o.report.Paths, joinPath(append(o.path[:len(o.path):len(o.path)], segments...)))
+}
@@ -0,0 +1,156 @@
+package fmutils
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestNestedMask_Report(t *testing.T) {
+	newProfile := func() *testproto.Profile {
+		return &testproto.Profile{
+			User:  &testproto.User{UserId: 1, Name: "name"},
+			Photo: &testproto.Photo{Path: "path", Dimensions: &testproto.Dimensions{Width: 1, Height: 2}},
+			Gallery: []*testproto.Photo{
+				{PhotoId: 1, Path: "path 1"},
+				{PhotoId: 2, Path: "path 2"},
+				{PhotoId: 3, Path: "path 3"},
+			},
+			Attributes: map[string]*testproto.Attribute{
+				"a1":          {Tags: map[string]string{"t1": "1", "t2": "2"}},
+				"example.com": {},
+			},
+		}
+	}
+	tests := []struct {
+		name  string
+		paths []string
+		prune bool
+		want  Report
+	}{
+		{
+			name:  "Filter reports fields, map entries and list elements",
+			paths: []string{"user.name", "photo.dimensions.width", "gallery.-1.path", "attributes.a1.tags.t1"},
+			want: Report{
+				Paths: []string{
+					"user.user_id",
+					"photo.path",
+					"photo.dimensions.height",
+					"gallery.0",
+					"gallery.1",
+					"gallery.2.photo_id",
+					"attributes.a1.tags.t2",
+					"attributes.`example.com`",
+				},
+				Fields:       4,
+				MapEntries:   2,
+				ListElements: 2,
+			},
+		},
+		{
+			name:  "Prune reports fields, map entries and list elements",
+			paths: []string{"user", "photo.dimensions.width", "gallery.0", "gallery.2.path", "attributes.a1.tags.t1"},
+			prune: true,
+			want: Report{
+				Paths: []string{
+					"user",
+					"photo.dimensions.width",
+					"gallery.0",
+					"gallery.2.path",
+					"attributes.a1.tags.t1",
+				},
+				Fields:       3,
+				MapEntries:   1,
+				ListElements: 1,
+			},
+		},
+		{
+			name:  "Prune reports nothing for absent fields",
+			paths: []string{"login_timestamps", "attributes.a2"},
+			prune: true,
+			want:  Report{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask := NestedMaskFromPaths(tt.paths)
+			report, apply := mask.FilterReport, mask.Filter
+			if tt.prune {
+				report, apply = mask.PruneReport, mask.Prune
+			}
+
+			want := newProfile()
+			apply(want)
+			msg := newProfile()
+			got := report(msg)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("report = %+v, want %+v", got, tt.want)
+			}
+			if !proto.Equal(msg, want) {
+				t.Errorf("msg = %v, want %v", msg, want)
+			}
+
+			msg = newProfile()
+			got = report(msg, WithDryRun())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dry run report = %+v, want %+v", got, tt.want)
+			}
+			if !proto.Equal(msg, newProfile()) {
+				t.Errorf("dry run modified the message: %v", msg)
+			}
+		})
+	}
+}
+
+func TestFilterReport(t *testing.T) {
+	msg := &testproto.Profile{User: &testproto.User{UserId: 1, Name: "name"}, LoginTimestamps: []int64{1, 2}}
+	got := FilterReport(msg, []string{"user.name"})
+	want := Report{Paths: []string{"user.user_id", "login_timestamps"}, Fields: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FilterReport() = %+v, want %+v", got, want)
+	}
+
+	msg = &testproto.Profile{User: &testproto.User{UserId: 1, Name: "name"}}
+	got = PruneReport(msg, []string{"user.name"}, WithDryRun())
+	want = Report{Paths: []string{"user.name"}, Fields: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PruneReport() = %+v, want %+v", got, want)
+	}
+	if msg.GetUser().GetName() != "name" {
+		t.Errorf("PruneReport() with WithDryRun modified the message: %v", msg)
+	}
+}
+
+func TestNestedMask_FilterReport_unknownFields(t *testing.T) {
+	older := olderProfileType(t, "testproto.Profile.attributes", "testproto.User.name", "testproto.Photo.dimensions")
+	current := &testproto.Profile{
+		User:       &testproto.User{UserId: 1, Name: "name"},
+		Photo:      &testproto.Photo{PhotoId: 1, Dimensions: &testproto.Dimensions{Width: 1}},
+		Attributes: map[string]*testproto.Attribute{"a1": {}, "a2": {}},
+	}
+	msg := roundTrip(t, current, older)
+	mask := NestedMaskFromPaths([]string{"user.user_id", "photo"})
+
+	want := Report{
+		UnknownFields: []UnknownFields{
+			{Path: "", Numbers: []protoreflect.FieldNumber{5}},
+			{Path: "user", Numbers: []protoreflect.FieldNumber{2}},
+		},
+	}
+	if got := mask.FilterReport(msg, WithDryRun()); !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run report = %+v, want %+v", got, want)
+	}
+	if got := mask.FilterReport(msg); !reflect.DeepEqual(got, want) {
+		t.Errorf("report = %+v, want %+v", got, want)
+	}
+	if got := mask.FilterReport(msg); !reflect.DeepEqual(got, Report{}) {
+		t.Errorf("report of the filtered message = %+v, want %+v", got, Report{})
+	}
+	if got := mask.FilterReport(roundTrip(t, current, older), WithUnknownFields()); !reflect.DeepEqual(got, Report{}) {
+		t.Errorf("report with WithUnknownFields = %+v, want %+v", got, Report{})
+	}
+}
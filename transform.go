@@ -0,0 +1,268 @@
+package fmutils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Path is the path of a value in a message: the segments are the field names, the map keys and the list indices.
+type Path []string
+
+// String returns the path with the segments separated with dots and quoted if needed.
+func (p Path) String() string {
+	return joinPath(p)
+}
+
+// TransformFunc returns the replacement of the value of a masked field.
+//
+// The fd is the descriptor of the field, for the list elements it is the descriptor of the list field and for the
+// map values it is the descriptor of the map value, so fd.Kind is always the kind of v.
+type TransformFunc func(path Path, fd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, error)
+
+// Transform replaces the values of the msg fields listed in the mask with the values returned by fn.
+//
+// The mask is traversed like NestedMask.Prune does: fn is called for every populated field that is listed as a whole,
+// for every element of such a list field and for every value of such a map field, and the value is replaced with the
+// result. The fields are visited in the order of their numbers and the map entries in the order of their keys.
+// If the mask is empty no fields are transformed.
+// The first error returned by fn stops the traversal and is returned, the values that are already replaced are kept.
+func (mask NestedMask) Transform(msg proto.Message, fn TransformFunc, opts ...Option) error {
+	if len(mask) == 0 {
+		return nil
+	}
+	rft, o := msg.ProtoReflect(), newOptions(opts)
+	w := &visitor{o: o, leaf: func(path Path, fd protoreflect.FieldDescriptor, v protoreflect.Value,
+		set func(protoreflect.Value)) error {
+		nv, err := fn(path, fd, v)
+		if err != nil {
+			return err
+		}
+		if err := checkValue(fd, nv); err != nil {
+			return fmt.Errorf("fmutils: can not set %s: %w", path, err)
+		}
+		set(nv)
+		return nil
+	}}
+	return o.protoNames(mask, rft.Descriptor()).visit(rft, nil, w)
+}
+
+// RedactValue is a TransformFunc that keeps the value present but hides its content: strings are replaced with "***",
+// bytes with their SHA-256 hash, messages with empty messages and all the other values with zero.
+func RedactValue(_ Path, fd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString("***"), nil
+	case protoreflect.BytesKind:
+		sum := sha256.Sum256(v.Bytes())
+		return protoreflect.ValueOfBytes(sum[:]), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return protoreflect.ValueOfMessage(v.Message().New()), nil
+	default:
+		return zeroValue(fd.Kind()), nil
+	}
+}
+
+// zeroValue returns the zero value of the scalar kind.
+func zeroValue(kind protoreflect.Kind) protoreflect.Value {
+	switch kind {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(false)
+	case protoreflect.EnumKind:
+		return protoreflect.ValueOfEnum(0)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(0)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(0)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(0)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(0)
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(0)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(0)
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString("")
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes(nil)
+	default:
+		return protoreflect.Value{}
+	}
+}
+
+// checkValue checks that the value is valid for a singular field, a list element or a map value of the given
+// descriptor.
+func checkValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) error {
+	var ok bool
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		_, ok = v.Interface().(bool)
+	case protoreflect.EnumKind:
+		_, ok = v.Interface().(protoreflect.EnumNumber)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		_, ok = v.Interface().(int32)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		_, ok = v.Interface().(int64)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		_, ok = v.Interface().(uint32)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		_, ok = v.Interface().(uint64)
+	case protoreflect.FloatKind:
+		_, ok = v.Interface().(float32)
+	case protoreflect.DoubleKind:
+		_, ok = v.Interface().(float64)
+	case protoreflect.StringKind:
+		_, ok = v.Interface().(string)
+	case protoreflect.BytesKind:
+		_, ok = v.Interface().([]byte)
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		var m protoreflect.Message
+		m, ok = v.Interface().(protoreflect.Message)
+		ok = ok && m.Descriptor().FullName() == fd.Message().FullName()
+	}
+	if !ok {
+		return fmt.Errorf("%T is not a valid %s value", v.Interface(), kindName(fd))
+	}
+	return nil
+}
+
+// kindName returns the name of the value kind of the field, the message kinds are named after the message type.
+func kindName(fd protoreflect.FieldDescriptor) string {
+	if fd.Message() != nil {
+		return string(fd.Message().FullName())
+	}
+	return fd.Kind().String()
+}
+
+// leafFunc is called for every value listed in the mask as a whole, set replaces the value.
+type leafFunc func(path Path, fd protoreflect.FieldDescriptor, v protoreflect.Value, set func(protoreflect.Value)) error
+
+// visitor traverses the values of a message listed in a mask.
+type visitor struct {
+	o    *options
+	leaf leafFunc
+	// readOnly is set if the leaf does not replace the values, so the messages packed into google.protobuf.Any fields
+	// are not packed back.
+	readOnly bool
+}
+
+// visit calls the leaf for the values of the message fields listed in the mask as a whole, an empty mask lists all the
+// fields.
+func (mask NestedMask) visit(m protoreflect.Message, path Path, w *visitor) error {
+	for _, fd := range populatedFields(m) {
+		fm, ok := mask.child(string(fd.Name()))
+		if !ok && len(mask) != 0 {
+			continue
+		}
+		if err := fm.visitField(m, fd, appendSegment(path, string(fd.Name())), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mask NestedMask) visitField(m protoreflect.Message, fd protoreflect.FieldDescriptor, path Path,
+	w *visitor) error {
+	switch {
+	case fd.IsMap():
+		if len(mask) != 0 {
+			mask = mask.withMapKeys(fd.MapKey())
+		}
+		xmap := m.Get(fd).Map()
+		for _, mk := range sortedMapKeys(xmap) {
+			km := mask
+			if len(mask) != 0 {
+				var ok bool
+				if km, ok = mask.child(mk.String()); !ok {
+					continue
+				}
+			}
+			mk := mk
+			set := func(v protoreflect.Value) { xmap.Set(mk, v) }
+			if err := km.visitValue(fd.MapValue(), xmap.Get(mk), appendSegment(path, mk.String()), w, set); err != nil {
+				return err
+			}
+		}
+	case fd.IsList():
+		list, l := m.Get(fd).List(), mask.listMask(fd)
+		for i := 0; i < list.Len(); i++ {
+			im, ok := l.element(i, list.Len(), list.Get(i))
+			if !ok {
+				continue
+			}
+			i := i
+			set := func(v protoreflect.Value) { list.Set(i, v) }
+			if err := im.visitValue(fd, list.Get(i), appendSegment(path, strconv.Itoa(i)), w, set); err != nil {
+				return err
+			}
+		}
+	default:
+		set := func(v protoreflect.Value) { m.Set(fd, v) }
+		return mask.visitValue(fd, m.Get(fd), path, w, set)
+	}
+	return nil
+}
+
+// visitValue visits a singular field value, a list element or a map value.
+func (mask NestedMask) visitValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, path Path, w *visitor,
+	set func(protoreflect.Value)) error {
+	switch {
+	case len(mask) == 0:
+		return w.leaf(path, fd, v, set)
+	case fd.Message() == nil:
+		return nil
+	case w.o.resolvesAny(fd.Message()) && w.readOnly:
+		packed, err := w.o.unpackAny(v.Message())
+		if err != nil {
+			return err
+		}
+		mask = w.o.protoNames(mask, packed.Descriptor())
+		return mask.visit(packed, path, w)
+	case w.o.resolvesAny(fd.Message()):
+		return w.o.applyToAny(v.Message(), mask, func(mask NestedMask, packed protoreflect.Message, _ *options) error {
+			return mask.visit(packed, path, w)
+		})
+	default:
+		return mask.visit(v.Message(), path, w)
+	}
+}
+
+// populatedFields returns the populated fields of the message in the order of their numbers.
+func populatedFields(m protoreflect.Message) []protoreflect.FieldDescriptor {
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Number() < fields[j].Number()
+	})
+	return fields
+}
+
+// sortedMapKeys returns the keys of the map in ascending order.
+func sortedMapKeys(m protoreflect.Map) []protoreflect.MapKey {
+	var keys []protoreflect.MapKey
+	m.Range(func(mk protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, mk)
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		switch a := keys[i].Interface().(type) {
+		case bool:
+			return !a && keys[j].Bool()
+		case int32, int64:
+			return keys[i].Int() < keys[j].Int()
+		case uint32, uint64:
+			return keys[i].Uint() < keys[j].Uint()
+		default:
+			return keys[i].String() < keys[j].String()
+		}
+	})
+	return keys
+}
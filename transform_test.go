@@ -0,0 +1,185 @@
+package fmutils
+
+import (
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestNestedMask_Transform(t *testing.T) {
+	tests := []struct {
+		name      string
+		paths     []string
+		msg       proto.Message
+		want      proto.Message
+		wantPaths []string
+	}{
+		{
+			name:  "empty mask transforms nothing",
+			paths: []string{},
+			msg:   &testproto.User{UserId: 1, Name: "name"},
+			want:  &testproto.User{UserId: 1, Name: "name"},
+		},
+		{
+			name:  "scalar fields",
+			paths: []string{"user", "photo.path", "photo.dimensions.width"},
+			msg: &testproto.Profile{
+				User:  &testproto.User{UserId: 1, Name: "name"},
+				Photo: &testproto.Photo{PhotoId: 2, Path: "path", Dimensions: &testproto.Dimensions{Width: 100, Height: 120}},
+			},
+			want: &testproto.Profile{
+				User:  &testproto.User{},
+				Photo: &testproto.Photo{PhotoId: 2, Path: "***", Dimensions: &testproto.Dimensions{Height: 120}},
+			},
+			wantPaths: []string{"user", "photo.path", "photo.dimensions.width"},
+		},
+		{
+			name:  "list elements",
+			paths: []string{"login_timestamps", "gallery.path"},
+			msg: &testproto.Profile{
+				LoginTimestamps: []int64{1, 2},
+				Gallery:         []*testproto.Photo{{PhotoId: 1, Path: "path 1"}, {PhotoId: 2}},
+			},
+			want: &testproto.Profile{
+				LoginTimestamps: []int64{0, 0},
+				Gallery:         []*testproto.Photo{{PhotoId: 1, Path: "***"}, {PhotoId: 2}},
+			},
+			wantPaths: []string{"login_timestamps.0", "login_timestamps.1", "gallery.0.path"},
+		},
+		{
+			name:  "map values",
+			paths: []string{"attributes.*.tags.t1", "attributes.a2.tags", "attributes.a3"},
+			msg: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+					"a2": {Tags: map[string]string{"t1": "1", "t2": "2"}},
+					"a3": {Tags: map[string]string{"t1": "1"}},
+				},
+			},
+			want: &testproto.Profile{
+				Attributes: map[string]*testproto.Attribute{
+					"a1": {Tags: map[string]string{"t1": "***", "t2": "2"}},
+					"a2": {Tags: map[string]string{"t1": "***", "t2": "***"}},
+					"a3": {},
+				},
+			},
+			wantPaths: []string{"attributes.a1.tags.t1", "attributes.a2.tags.t1", "attributes.a2.tags.t2", "attributes.a3"},
+		},
+		{
+			name:  "integer map keys",
+			paths: []string{"scores.+2.comment", "labels"},
+			msg: &testproto.Leaderboard{
+				Scores: map[int64]*testproto.Score{2: {Value: 1, Comment: "c"}, 10: {Comment: "c"}},
+				Labels: map[int64]string{10: "ten", -1: "minus one"},
+			},
+			want: &testproto.Leaderboard{
+				Scores: map[int64]*testproto.Score{2: {Value: 1, Comment: "***"}, 10: {Comment: "c"}},
+				Labels: map[int64]string{10: "***", -1: "***"},
+			},
+			wantPaths: []string{"scores.2.comment", "labels.-1", "labels.10"},
+		},
+		{
+			name:  "unpopulated fields are not visited",
+			paths: []string{"user.name", "photo", "gallery"},
+			msg:   &testproto.Profile{User: &testproto.User{UserId: 1}},
+			want:  &testproto.Profile{User: &testproto.User{UserId: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			err := NestedMaskFromPaths(tt.paths).Transform(tt.msg, func(path Path, fd protoreflect.FieldDescriptor,
+				v protoreflect.Value) (protoreflect.Value, error) {
+				paths = append(paths, path.String())
+				return RedactValue(path, fd, v)
+			})
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if !proto.Equal(tt.msg, tt.want) {
+				t.Errorf("msg %v, want %v", tt.msg, tt.want)
+			}
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("visited paths %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestNestedMask_Transform_errors(t *testing.T) {
+	msg := &testproto.Profile{
+		User:  &testproto.User{UserId: 1, Name: "name"},
+		Photo: &testproto.Photo{PhotoId: 2},
+	}
+	mask := NestedMaskFromPaths([]string{"user.name", "photo.photo_id"})
+
+	errStop := errors.New("stop")
+	err := mask.Transform(msg, func(Path, protoreflect.FieldDescriptor, protoreflect.Value) (protoreflect.Value, error) {
+		return protoreflect.Value{}, errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("Transform() error = %v, want %v", err, errStop)
+	}
+
+	err = mask.Transform(msg, func(Path, protoreflect.FieldDescriptor, protoreflect.Value) (protoreflect.Value, error) {
+		return protoreflect.ValueOfInt32(1), nil
+	})
+	if err == nil {
+		t.Error("Transform() error = nil, want an error for a value of a wrong type")
+	}
+	want := &testproto.Profile{
+		User:  &testproto.User{UserId: 1, Name: "name"},
+		Photo: &testproto.Photo{PhotoId: 2},
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
+
+func TestNestedMask_Transform_WithAnyResolver(t *testing.T) {
+	msg := &testproto.Event{
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})},
+	}
+	err := NestedMaskFromPaths([]string{"details.data"}).Transform(msg, RedactValue,
+		WithAnyResolver(protoregistry.GlobalTypes))
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	sum := sha256.Sum256([]byte("bytes"))
+	want := &testproto.Event{
+		Changed: &testproto.Event_Details{Details: createAny(&testproto.Result{Data: sum[:], NextToken: 1})},
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
+
+func TestRedactValue(t *testing.T) {
+	msg := &testproto.Event{
+		EventId: 1,
+		Changed: &testproto.Event_Status{Status: testproto.Status_OK},
+	}
+	if err := NestedMaskFromPaths([]string{"event_id", "status"}).Transform(msg, RedactValue); err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	want := &testproto.Event{Changed: &testproto.Event_Status{Status: testproto.Status_UNKNOWN}}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+
+	details := &testproto.Event{Changed: &testproto.Event_Details{Details: &anypb.Any{TypeUrl: "url"}}}
+	if err := NestedMaskFromPaths([]string{"details"}).Transform(details, RedactValue); err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if want := (&testproto.Event{Changed: &testproto.Event_Details{Details: &anypb.Any{}}}); !proto.Equal(details, want) {
+		t.Errorf("msg %v, want %v", details, want)
+	}
+}
@@ -0,0 +1,328 @@
+package fmutils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var (
+	// ErrUnknownField is reported when a path segment does not name a field of the message.
+	ErrUnknownField = errors.New("unknown field")
+	// ErrScalarDescent is reported when a path descends into a field that is not a message.
+	ErrScalarDescent = errors.New("cannot descend into a non-message field")
+	// ErrInvalidMapKey is reported when a path segment can not be parsed as a key of the map.
+	ErrInvalidMapKey = errors.New("invalid map key")
+	// ErrInvalidWildcard is reported when a wildcard that matches the message fields is not the last path segment or
+	// when a wildcard is used in the path of a single value, e.g. in Get.
+	ErrInvalidWildcard = errors.New("wildcard for message fields must be the last path segment")
+	// ErrInvalidIndex is reported when a path segment is not an index of an existing list element.
+	ErrInvalidIndex = errors.New("invalid list index")
+	// ErrInvalidSelector is reported when a selector, e.g. "[photo_id=2]", does not name a singular scalar field of the
+	// list elements or its value can not be parsed, or when no list element matches it in Get and Set.
+	ErrInvalidSelector = errors.New("invalid list element selector")
+)
+
+// PathError describes a single invalid path in a field mask.
+type PathError struct {
+	// Path is the full path that failed the validation.
+	Path string
+	// Segment is the path segment at which the validation failed.
+	Segment string
+	// Err is the reason of the failure, e.g. ErrUnknownField.
+	Err error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("invalid path %q at segment %q: %v", e.Path, e.Segment, e.Err)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError lists all the invalid paths found in a field mask.
+//
+// It matches any of the ErrUnknownField, ErrScalarDescent, ErrInvalidMapKey, ErrInvalidWildcard, ErrInvalidIndex and
+// ErrInvalidSelector errors with errors.Is if at least one of the paths failed for that reason.
+type ValidationError struct {
+	Errors []*PathError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, pe := range e.Errors {
+		msgs[i] = pe.Error()
+	}
+	return "fmutils: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the path errors matches the target.
+func (e *ValidationError) Is(target error) bool {
+	for _, pe := range e.Errors {
+		if errors.Is(pe, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePaths checks that all the paths are well-formed and valid for the given message descriptor.
+//
+// This is a handy wrapper for ParseNestedMask and NestedMask.Validate methods.
+func ValidatePaths(md protoreflect.MessageDescriptor, paths []string, opts ...Option) error {
+	mask, err := ParseNestedMask(paths)
+	if err != nil {
+		return err
+	}
+	return mask.Validate(md, opts...)
+}
+
+// FilterChecked validates the paths against the msg descriptor and then keeps the msg fields that are listed in the
+// paths clearing all the rest.
+//
+// This is a handy wrapper for ParseNestedMask and NestedMask.FilterChecked methods.
+func FilterChecked(msg proto.Message, paths []string, opts ...Option) error {
+	mask, err := ParseNestedMask(paths)
+	if err != nil {
+		return err
+	}
+	return mask.FilterChecked(msg, opts...)
+}
+
+// PruneChecked validates the paths against the msg descriptor and then clears all the fields listed in the paths.
+//
+// This is a handy wrapper for ParseNestedMask and NestedMask.PruneChecked methods.
+func PruneChecked(msg proto.Message, paths []string, opts ...Option) error {
+	mask, err := ParseNestedMask(paths)
+	if err != nil {
+		return err
+	}
+	return mask.PruneChecked(msg, opts...)
+}
+
+// Validate checks that the mask is valid for the given message descriptor.
+//
+// The mask is walked recursively through nested messages, repeated fields, maps and oneofs.
+// If any of the paths is invalid a *ValidationError is returned that lists every invalid path.
+// The subfields of the google.protobuf.Any fields are not validated if the WithAnyResolver option is given since the
+// type of the packed message is not known in advance.
+func (mask NestedMask) Validate(md protoreflect.MessageDescriptor, opts ...Option) error {
+	o := newOptions(opts)
+	return o.protoNames(mask, md).validate(md, o)
+}
+
+func (mask NestedMask) validate(md protoreflect.MessageDescriptor, o *options) error {
+	var errs []*PathError
+	mask.validateMessage(md, nil, o, &errs)
+	if len(errs) != 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// FilterChecked is the same as NestedMask.Filter except that the mask is validated first.
+//
+// If the mask is invalid for the msg descriptor or any of its indices is out of range of the lists of the msg, then the
+// msg is left untouched and a *ValidationError is returned.
+// If the WithAnyResolver option is given then the mask is also validated against the messages packed into the
+// google.protobuf.Any fields, and an error is returned if any of them can not be unpacked. In that case the msg may be
+// partially filtered and the Any fields that failed are reset.
+// This method is safe to use with untrusted masks.
+func (mask NestedMask) FilterChecked(msg proto.Message, opts ...Option) error {
+	rft, o := msg.ProtoReflect(), newOptions(opts)
+	mask = o.protoNames(mask, rft.Descriptor())
+	if err := mask.check(rft, o); err != nil {
+		return err
+	}
+	o.checked = true
+	return mask.filter(rft, o)
+}
+
+// PruneChecked is the same as NestedMask.Prune except that the mask is validated first.
+//
+// If the mask is invalid for the msg descriptor or any of its indices is out of range of the lists of the msg, then the
+// msg is left untouched and a *ValidationError is returned.
+// If the WithAnyResolver option is given then the mask is also validated against the messages packed into the
+// google.protobuf.Any fields, and an error is returned if any of them can not be unpacked. In that case the msg may be
+// partially pruned and the Any fields that failed are reset.
+// This method is safe to use with untrusted masks.
+func (mask NestedMask) PruneChecked(msg proto.Message, opts ...Option) error {
+	rft, o := msg.ProtoReflect(), newOptions(opts)
+	mask = o.protoNames(mask, rft.Descriptor())
+	if err := mask.check(rft, o); err != nil {
+		return err
+	}
+	o.checked = true
+	return mask.prune(rft, o)
+}
+
+// FilterCopyChecked is the same as NestedMask.FilterCopy except that the mask is validated first.
+//
+// If the mask is invalid for the msg descriptor or any of its indices is out of range of the lists of the msg, then
+// nil and a *ValidationError are returned. The msg is left untouched in any case.
+// If the WithAnyResolver option is given then the mask is also validated against the messages packed into the
+// google.protobuf.Any fields, and an error is returned along with the copy if any of them can not be unpacked.
+// This method is safe to use with untrusted masks.
+func (mask NestedMask) FilterCopyChecked(msg proto.Message, opts ...Option) (proto.Message, error) {
+	src, o := msg.ProtoReflect(), newOptions(opts)
+	mask = o.protoNames(mask, src.Descriptor())
+	if err := mask.check(src, o); err != nil {
+		return nil, err
+	}
+	o.checked = true
+	dst := src.New()
+	err := mask.filterCopy(dst, src, o)
+	return dst.Interface(), err
+}
+
+// check validates the mask against the descriptor of the message and checks that its indices are in range of the
+// lists of the message leaving the message untouched.
+func (mask NestedMask) check(rft protoreflect.Message, o *options) error {
+	if err := mask.validate(rft.Descriptor(), o); err != nil {
+		return err
+	}
+	var errs []*PathError
+	mask.checkIndices(rft, nil, o, &errs)
+	if len(errs) != 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func (mask NestedMask) validateMessage(md protoreflect.MessageDescriptor, prefix []string, o *options,
+	errs *[]*PathError) {
+	for _, key := range mask.sortedKeys() {
+		path := appendSegment(prefix, key)
+		if key == exclusion {
+			validateExcluded(errs, func(errs *[]*PathError) {
+				mask[key].validateMessage(md, prefix, o, errs)
+			})
+			continue
+		}
+		if key == wildcard {
+			for _, k := range mask[key].sortedKeys() {
+				mask[key][k].reportInvalid(appendSegment(path, k), key, ErrInvalidWildcard, errs)
+			}
+			continue
+		}
+		fd := md.Fields().ByName(protoreflect.Name(key))
+		if fd == nil {
+			mask[key].reportInvalid(path, key, ErrUnknownField, errs)
+			continue
+		}
+		mask[key].validateField(fd, path, o, errs)
+	}
+}
+
+func (mask NestedMask) validateField(fd protoreflect.FieldDescriptor, path []string, o *options,
+	errs *[]*PathError) {
+	mask, excludes := mask.split()
+	if excludes != nil {
+		validateExcluded(errs, func(errs *[]*PathError) {
+			excludes.validateField(fd, path, o, errs)
+		})
+	}
+	if fd.IsList() {
+		l := mask.listMask(fd)
+		for _, key := range mask.sortedKeys() {
+			if field, value, ok := splitSelector(key); ok {
+				if _, _, err := resolveSelector(fd, field, value); err != nil {
+					mask[key].reportInvalid(appendSegment(path, key), key, ErrInvalidSelector, errs)
+					continue
+				}
+			} else if _, ok := parseIndex(key); !ok {
+				continue
+			}
+			mask[key].validateValue(fd, appendSegment(path, key), o, errs)
+		}
+		l.all.validateValue(fd, path, o, errs)
+		return
+	}
+	if !fd.IsMap() {
+		mask.validateValue(fd, path, o, errs)
+		return
+	}
+
+	for _, key := range mask.sortedKeys() {
+		keyPath := appendSegment(path, key)
+		if key == wildcard {
+			mask[key].validateValue(fd.MapValue(), keyPath, o, errs)
+			continue
+		}
+		if _, err := parseMapKey(fd.MapKey(), key); err != nil {
+			mask[key].reportInvalid(keyPath, key, ErrInvalidMapKey, errs)
+			continue
+		}
+		mask[key].validateValue(fd.MapValue(), keyPath, o, errs)
+	}
+}
+
+// validateValue validates the mask against a singular value of the field, a list element or a map value.
+func (mask NestedMask) validateValue(fd protoreflect.FieldDescriptor, path []string, o *options,
+	errs *[]*PathError) {
+	if len(mask) == 0 || fd.Message() != nil && o.resolvesAny(fd.Message()) {
+		return
+	}
+	if fd.Message() == nil {
+		for _, key := range mask.sortedKeys() {
+			mask[key].reportInvalid(appendSegment(path, key), key, ErrScalarDescent, errs)
+		}
+		return
+	}
+	mask.validateMessage(fd.Message(), path, o, errs)
+}
+
+// validateExcluded adds the errors reported by the validate function for the excluded paths, the paths get the
+// leading '-'.
+func validateExcluded(errs *[]*PathError, validate func(*[]*PathError)) {
+	var excluded []*PathError
+	validate(&excluded)
+	for _, pe := range excluded {
+		pe.Path = exclusionPrefix + pe.Path
+		*errs = append(*errs, pe)
+	}
+}
+
+// reportInvalid adds an error for every path of the mask that starts with the given path.
+func (mask NestedMask) reportInvalid(path []string, segment string, reason error, errs *[]*PathError) {
+	for _, p := range mask.appendPaths(nil, path) {
+		*errs = append(*errs, &PathError{Path: p, Segment: segment, Err: reason})
+	}
+}
+
+// parseMapKey parses the path segment as a key of a map with the given key descriptor.
+func parseMapKey(kd protoreflect.FieldDescriptor, s string) (protoreflect.MapKey, error) {
+	switch kd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s).MapKey(), nil
+	case protoreflect.BoolKind:
+		switch s {
+		case "true":
+			return protoreflect.ValueOfBool(true).MapKey(), nil
+		case "false":
+			return protoreflect.ValueOfBool(false).MapKey(), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if v, err := strconv.ParseInt(s, 10, 32); err == nil {
+			return protoreflect.ValueOfInt32(int32(v)).MapKey(), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return protoreflect.ValueOfInt64(v).MapKey(), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+			return protoreflect.ValueOfUint32(uint32(v)).MapKey(), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return protoreflect.ValueOfUint64(v).MapKey(), nil
+		}
+	}
+	return protoreflect.MapKey{}, fmt.Errorf("%q is not a valid %s map key", s, kd.Kind())
+}
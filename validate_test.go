@@ -0,0 +1,193 @@
+package fmutils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestNestedMask_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		msg   proto.Message
+		want  []*PathError
+	}{
+		{
+			name:  "empty mask is valid",
+			paths: []string{},
+			msg:   &testproto.Profile{},
+		},
+		{
+			name:  "valid nested paths",
+			paths: []string{"user.name", "photo.dimensions.width", "login_timestamps", "gallery.path"},
+			msg:   &testproto.Profile{},
+		},
+		{
+			name:  "valid map paths",
+			paths: []string{"attributes.a1.tags.t1", "attributes.a2"},
+			msg:   &testproto.Profile{},
+		},
+		{
+			name:  "valid oneof paths",
+			paths: []string{"user.name", "photo.dimensions", "status", "details.type_url", "profile.attributes"},
+			msg:   &testproto.Event{},
+		},
+		{
+			name:  "unknown root field",
+			paths: []string{"user", "unknown.a", "unknown.b"},
+			msg:   &testproto.Profile{},
+			want: []*PathError{
+				{Path: "unknown.a", Segment: "unknown", Err: ErrUnknownField},
+				{Path: "unknown.b", Segment: "unknown", Err: ErrUnknownField},
+			},
+		},
+		{
+			name:  "unknown nested field",
+			paths: []string{"photo.dimensions.depth", "gallery.size", "attributes.a1.labels"},
+			msg:   &testproto.Profile{},
+			want: []*PathError{
+				{Path: "attributes.a1.labels", Segment: "labels", Err: ErrUnknownField},
+				{Path: "gallery.size", Segment: "size", Err: ErrUnknownField},
+				{Path: "photo.dimensions.depth", Segment: "depth", Err: ErrUnknownField},
+			},
+		},
+		{
+			name:  "descending into scalars",
+			paths: []string{"user.name.first", "login_timestamps.value", "attributes.a1.tags.t1.value"},
+			msg:   &testproto.Profile{},
+			want: []*PathError{
+				{Path: "attributes.a1.tags.t1.value", Segment: "value", Err: ErrScalarDescent},
+				{Path: "login_timestamps.value", Segment: "value", Err: ErrScalarDescent},
+				{Path: "user.name.first", Segment: "first", Err: ErrScalarDescent},
+			},
+		},
+		{
+			name:  "valid wildcard paths",
+			paths: []string{"user.*", "attributes.*.tags.*", "gallery.*"},
+			msg:   &testproto.Profile{},
+		},
+		{
+			name:  "invalid wildcard paths",
+			paths: []string{"user.*.name", "attributes.*.labels"},
+			msg:   &testproto.Profile{},
+			want: []*PathError{
+				{Path: "attributes.*.labels", Segment: "labels", Err: ErrUnknownField},
+				{Path: "user.*.name", Segment: "*", Err: ErrInvalidWildcard},
+			},
+		},
+		{
+			name: "valid non-string map keys",
+			paths: []string{"scores.42.value", "scores.+042", "int32_scores.-1", "sint32_scores.2", "sfixed64_scores.-3",
+				"uint32_scores.4294967295", "uint64_scores.18446744073709551615", "fixed32_scores.0", "bool_scores.false"},
+			msg: &testproto.Leaderboard{},
+		},
+		{
+			name:  "invalid non-string map keys",
+			paths: []string{"scores.abc.value", "scores.1.5", "uint32_scores.-1", "int32_scores.2147483648", "bool_scores.yes"},
+			msg:   &testproto.Leaderboard{},
+			want: []*PathError{
+				{Path: "bool_scores.yes", Segment: "yes", Err: ErrInvalidMapKey},
+				{Path: "int32_scores.2147483648", Segment: "2147483648", Err: ErrInvalidMapKey},
+				{Path: "scores.1.5", Segment: "5", Err: ErrUnknownField},
+				{Path: "scores.abc.value", Segment: "abc", Err: ErrInvalidMapKey},
+				{Path: "uint32_scores.-1", Segment: "-1", Err: ErrInvalidMapKey},
+			},
+		},
+		{
+			name:  "descending into a scalar oneof field",
+			paths: []string{"status.code"},
+			msg:   &testproto.Event{},
+			want: []*PathError{
+				{Path: "status.code", Segment: "code", Err: ErrScalarDescent},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NestedMaskFromPaths(tt.paths).Validate(tt.msg.ProtoReflect().Descriptor())
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() error = %v, want *ValidationError", err)
+			}
+			if !reflect.DeepEqual(verr.Errors, tt.want) {
+				t.Errorf("Validate() errors = %v, want %v", verr.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationError_Is(t *testing.T) {
+	err := ValidatePaths((&testproto.Profile{}).ProtoReflect().Descriptor(), []string{"user.name.first", "unknown"})
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("errors.Is(%v, ErrUnknownField) = false, want true", err)
+	}
+	if !errors.Is(err, ErrScalarDescent) {
+		t.Errorf("errors.Is(%v, ErrScalarDescent) = false, want true", err)
+	}
+	if errors.Is(err, ErrInvalidMapKey) {
+		t.Errorf("errors.Is(%v, ErrInvalidMapKey) = true, want false", err)
+	}
+}
+
+func TestValidatePaths_malformed(t *testing.T) {
+	err := ValidatePaths((&testproto.Profile{}).ProtoReflect().Descriptor(), []string{"user..name"})
+	var serr *SyntaxError
+	if !errors.As(err, &serr) {
+		t.Errorf("ValidatePaths() error = %v, want *SyntaxError", err)
+	}
+}
+
+func TestFilterChecked(t *testing.T) {
+	msg := &testproto.Profile{
+		User:            &testproto.User{UserId: 1, Name: "user name"},
+		LoginTimestamps: []int64{1, 2},
+	}
+	want := proto.Clone(msg)
+	// This mask would make NestedMask.Filter panic.
+	if err := FilterChecked(msg, []string{"login_timestamps.value"}); !errors.Is(err, ErrScalarDescent) {
+		t.Errorf("FilterChecked() error = %v, want %v", err, ErrScalarDescent)
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+
+	if err := FilterChecked(msg, []string{"user.name"}); err != nil {
+		t.Fatalf("FilterChecked() error = %v, want nil", err)
+	}
+	want = &testproto.Profile{User: &testproto.User{Name: "user name"}}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
+
+func TestPruneChecked(t *testing.T) {
+	msg := &testproto.Profile{
+		User:    &testproto.User{UserId: 1, Name: "user name"},
+		Gallery: []*testproto.Photo{{PhotoId: 1, Path: "path"}},
+	}
+	if err := PruneChecked(msg, []string{"gallery.unknown"}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("PruneChecked() error = %v, want %v", err, ErrUnknownField)
+	}
+
+	if err := PruneChecked(msg, []string{"user.name", "gallery.path"}); err != nil {
+		t.Fatalf("PruneChecked() error = %v, want nil", err)
+	}
+	want := &testproto.Profile{
+		User:    &testproto.User{UserId: 1},
+		Gallery: []*testproto.Photo{{PhotoId: 1}},
+	}
+	if !proto.Equal(msg, want) {
+		t.Errorf("msg %v, want %v", msg, want)
+	}
+}
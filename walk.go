@@ -0,0 +1,36 @@
+package fmutils
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SkipAll is used as a return value from WalkFunc to stop the traversal, NestedMask.Walk returns nil in this case.
+var SkipAll = errors.New("skip all fields")
+
+// WalkFunc is called by NestedMask.Walk for every visited value.
+//
+// The fd is the descriptor of the field, for the list elements it is the descriptor of the list field and for the map
+// values it is the descriptor of the map value, like in TransformFunc.
+type WalkFunc func(path Path, fd protoreflect.FieldDescriptor, v protoreflect.Value) error
+
+// Walk calls fn for the values of the msg fields that NestedMask.Filter would keep, the msg is not modified.
+//
+// fn is called for every populated field that is listed as a whole, for every element of such a list field and for
+// every value of such a map field. If the mask is empty fn is called for all the populated fields of the msg.
+// The fields are visited in the order of their numbers and the map entries in the order of their keys.
+// If fn returns SkipAll the traversal stops and Walk returns nil, any other error stops the traversal and is returned.
+func (mask NestedMask) Walk(msg proto.Message, fn WalkFunc, opts ...Option) error {
+	rft, o := msg.ProtoReflect(), newOptions(opts)
+	w := &visitor{o: o, readOnly: true, leaf: func(path Path, fd protoreflect.FieldDescriptor, v protoreflect.Value,
+		_ func(protoreflect.Value)) error {
+		return fn(path, fd, v)
+	}}
+	err := o.protoNames(mask, rft.Descriptor()).visit(rft, nil, w)
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package fmutils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"github.com/mennanov/fmutils/testproto"
+)
+
+func TestNestedMask_Walk(t *testing.T) {
+	profile := &testproto.Profile{
+		User:            &testproto.User{UserId: 1, Name: "name"},
+		Photo:           &testproto.Photo{Path: "path", Dimensions: &testproto.Dimensions{Width: 100}},
+		LoginTimestamps: []int64{1, 2},
+		Gallery:         []*testproto.Photo{{PhotoId: 1, Path: "path 1"}, {PhotoId: 2}},
+		Attributes: map[string]*testproto.Attribute{
+			"a2": {Tags: map[string]string{"t1": "1"}},
+			"a1": {Tags: map[string]string{"t2": "2", "t1": "1"}},
+		},
+	}
+	tests := []struct {
+		name  string
+		paths []string
+		msg   proto.Message
+		want  []string
+	}{
+		{
+			name:  "empty mask visits all populated fields",
+			paths: []string{},
+			msg:   &testproto.User{UserId: 1},
+			want:  []string{"user_id=1"},
+		},
+		{
+			name:  "nested fields in the order of their numbers",
+			paths: []string{"photo.path", "user.name", "photo.photo_id", "user.user_id"},
+			msg:   profile,
+			want:  []string{"user.user_id=1", "user.name=name", "photo.path=path"},
+		},
+		{
+			name:  "list elements",
+			paths: []string{"login_timestamps", "gallery.path"},
+			msg:   profile,
+			want:  []string{"login_timestamps.0=1", "login_timestamps.1=2", "gallery.0.path=path 1"},
+		},
+		{
+			name:  "map values",
+			paths: []string{"attributes.*.tags.t1", "attributes.a1.tags"},
+			msg:   profile,
+			want:  []string{"attributes.a1.tags.t1=1", "attributes.a1.tags.t2=2", "attributes.a2.tags.t1=1"},
+		},
+		{
+			name:  "integer map keys",
+			paths: []string{"labels"},
+			msg:   &testproto.Leaderboard{Labels: map[int64]string{10: "ten", -1: "minus one", 2: "two"}},
+			want:  []string{"labels.-1=minus one", "labels.2=two", "labels.10=ten"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := proto.Clone(tt.msg)
+			var got []string
+			err := NestedMaskFromPaths(tt.paths).Walk(tt.msg, func(path Path, fd protoreflect.FieldDescriptor,
+				v protoreflect.Value) error {
+				got = append(got, fmt.Sprintf("%s=%v", path, v))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Walk() visited %q, want %q", got, tt.want)
+			}
+			if !proto.Equal(tt.msg, before) {
+				t.Errorf("msg %v, want %v", tt.msg, before)
+			}
+		})
+	}
+}
+
+func TestNestedMask_Walk_stop(t *testing.T) {
+	msg := &testproto.Profile{
+		User:            &testproto.User{UserId: 1, Name: "name"},
+		LoginTimestamps: []int64{1, 2, 3},
+	}
+	errStop := errors.New("stop")
+	tests := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{name: "SkipAll", err: SkipAll, wantErr: nil},
+		{name: "error", err: errStop, wantErr: errStop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := NestedMask{}.Walk(msg, func(path Path, _ protoreflect.FieldDescriptor, _ protoreflect.Value) error {
+				got = append(got, path.String())
+				if path.String() == "login_timestamps.1" {
+					return tt.err
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Errorf("Walk() error = %v, want %v", err, tt.wantErr)
+			}
+			want := []string{"user", "login_timestamps.0", "login_timestamps.1"}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Walk() visited %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNestedMask_Walk_WithAnyResolver(t *testing.T) {
+	details := createAny(&testproto.Result{Data: []byte("bytes"), NextToken: 1})
+	msg := &testproto.Event{EventId: 1, Changed: &testproto.Event_Details{Details: details}}
+	before := proto.Clone(msg)
+
+	var got []string
+	err := NestedMaskFromPaths([]string{"details.next_token"}).Walk(msg, func(path Path,
+		_ protoreflect.FieldDescriptor, v protoreflect.Value) error {
+		got = append(got, fmt.Sprintf("%s=%v", path, v))
+		return nil
+	}, WithAnyResolver(protoregistry.GlobalTypes))
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	if want := []string{"details.next_token=1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() visited %q, want %q", got, want)
+	}
+	if !proto.Equal(msg, before) {
+		t.Errorf("msg %v, want %v", msg, before)
+	}
+}